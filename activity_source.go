@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// ActivitySource reports activity of the task, combined with the other sources by --activity-mode.
+type ActivitySource interface {
+	// Name identifies the source in logs and stop reasons.
+	Name() string
+	Run(ctx context.Context) error
+	LastActivity() time.Time
+}
+
+const (
+	ActivityModeAny = "any"
+	ActivityModeAll = "all"
+)
+
+// LogActivitySource tails a log file of the application, such as an nginx access log,
+// and records the time when a line matching any of the patterns is written.
+type LogActivitySource struct {
+	path     string
+	patterns []*regexp.Regexp
+	tailMode TailMode
+	logger   *slog.Logger
+
+	mu           sync.RWMutex
+	lastActivity time.Time
+}
+
+// ParseActivityLogs parses the PATH=REGEX specs of --activity-log into one source per path.
+// Any line is activity if REGEX is omitted.
+func ParseActivityLogs(specs []string, tailMode TailMode, logger *slog.Logger) ([]*LogActivitySource, error) {
+	sources := make([]*LogActivitySource, 0, len(specs))
+	byPath := map[string]*LogActivitySource{}
+	for _, spec := range specs {
+		path, pattern, _ := strings.Cut(spec, "=")
+		if path == "" {
+			return nil, fmt.Errorf("invalid activity log %q: path is empty", spec)
+		}
+		if pattern == "" {
+			pattern = ".*"
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid activity log %q: %w", spec, err)
+		}
+		source, ok := byPath[path]
+		if !ok {
+			source = &LogActivitySource{
+				path:     path,
+				tailMode: tailMode,
+				logger:   logger,
+			}
+			byPath[path] = source
+			sources = append(sources, source)
+		}
+		source.patterns = append(source.patterns, re)
+	}
+	return sources, nil
+}
+
+// Run tails the log file from the end, lines written before Run are not activity.
+func (s *LogActivitySource) Run(ctx context.Context) error {
+	start, err := s.waitLogFile(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	reader, err := NewTailReaderWithOptions(ctx, s.path, TailReaderOptions{
+		Mode:          s.tailMode,
+		StartPosition: start,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return s.RunWithReader(ctx, reader)
+}
+
+// waitLogFile returns the end of the log file, or nil to read from the start if the log file is created after Run.
+func (s *LogActivitySource) waitLogFile(ctx context.Context) (*TailPosition, error) {
+	for waited := false; ; waited = true {
+		stats, err := os.Stat(s.path)
+		switch {
+		case err == nil && waited:
+			return nil, nil
+		case err == nil:
+			return &TailPosition{FileID: fileIDOf(stats), Offset: stats.Size()}, nil
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+		if !waited && s.logger != nil {
+			s.logger.DebugContext(ctx, "waiting for activity log", "path", s.path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *LogActivitySource) RunWithReader(ctx context.Context, reader *TailReader) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		if s.Match(scanner.Text()) {
+			s.mu.Lock()
+			s.lastActivity = flextime.Now()
+			s.mu.Unlock()
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func (s *LogActivitySource) Match(line string) bool {
+	for _, re := range s.patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *LogActivitySource) Name() string {
+	return "activity log " + s.path
+}
+
+func (s *LogActivitySource) LastActivity() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastActivity
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActivityLogs(t *testing.T) {
+	sources, err := ParseActivityLogs([]string{
+		"/var/log/nginx/access.log= 200 ",
+		"/var/log/pgbouncer.log",
+		"/var/log/nginx/access.log= 304 ",
+	}, TailModePoll, nil)
+	require.NoError(t, err)
+	require.Len(t, sources, 2)
+	require.Equal(t, "/var/log/nginx/access.log", sources[0].path)
+	require.True(t, sources[0].Match(`10.0.0.1 - - "GET / HTTP/1.1" 304 0`))
+	require.False(t, sources[0].Match(`10.0.0.1 - - "GET / HTTP/1.1" 404 0`))
+	require.True(t, sources[1].Match("any line"))
+
+	_, err = ParseActivityLogs([]string{"=foo"}, TailModePoll, nil)
+	require.Error(t, err)
+	_, err = ParseActivityLogs([]string{"/var/log/app.log=("}, TailModePoll, nil)
+	require.Error(t, err)
+}
+
+func TestLogActivitySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte("GET /before 200\n"), 0644))
+	sources, err := ParseActivityLogs([]string{path + "= 200$"}, TailModePoll, nil)
+	require.NoError(t, err)
+	source := sources[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	var runErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = source.Run(ctx)
+	}()
+	time.Sleep(700 * time.Millisecond)
+	require.True(t, source.LastActivity().IsZero(), "lines written before Run are not activity")
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	fmt.Fprintln(f, "GET /health 404")
+	time.Sleep(700 * time.Millisecond)
+	require.True(t, source.LastActivity().IsZero())
+
+	before := time.Now()
+	fmt.Fprintln(f, "GET /index 200")
+	require.Eventually(t, func() bool {
+		return !source.LastActivity().Before(before)
+	}, 3*time.Second, 100*time.Millisecond)
+	cancel()
+	wg.Wait()
+	require.NoError(t, runErr)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+type AgentStatus string
+
+const (
+	// AgentStatusUnknown means no line of the SSM agent has been read yet.
+	AgentStatusUnknown  AgentStatus = ""
+	AgentStatusStarting AgentStatus = "starting"
+	AgentStatusReady    AgentStatus = "ready"
+	AgentStatusStopped  AgentStatus = "stopped"
+	// AgentStatusLogNotFound means the log of the SSM agent was not created within the agent ready timeout.
+	AgentStatusLogNotFound AgentStatus = "log-not-found"
+)
+
+const (
+	AgentFailurePolicyTerminate   = "terminate"
+	AgentFailurePolicyKeepRunning = "keep-running"
+	AgentFailurePolicyExitError   = "exit-error"
+)
+
+const (
+	InitialWaitFromStart      = "start"
+	InitialWaitFromAgentReady = "agent-ready"
+)
+
+type AgentHealth struct {
+	Status    AgentStatus `json:"status"`
+	StartedAt time.Time   `json:"started_at"`
+	ReadyAt   time.Time   `json:"ready_at"`
+	StoppedAt time.Time   `json:"stopped_at"`
+	Restarts  int         `json:"restarts"`
+}
+
+// markAgentLocked tracks the health of the SSM agent from lines without a session.
+func (m *Monitor) markAgentLocked(e LogEntry) {
+	switch {
+	case e.IsAgentStarted():
+		// the first start of the agent in the log is not a restart, unless sessions were already seen.
+		if (m.agent.Status != AgentStatusUnknown && m.agent.Status != AgentStatusLogNotFound) || len(m.lastTimestamps) > 0 {
+			m.agent.Restarts++
+			m.publish(SessionEvent{Type: SessionEventAgentRestarted, Time: e.Timestamp})
+		}
+		m.agent = AgentHealth{
+			Status:    AgentStatusStarting,
+			StartedAt: e.Timestamp,
+			Restarts:  m.agent.Restarts,
+		}
+	case e.IsAgentReady():
+		m.agent.Status = AgentStatusReady
+		m.agent.ReadyAt = e.Timestamp
+	case e.IsAgentStopping():
+		m.agent.Status = AgentStatusStopped
+		m.agent.StoppedAt = e.Timestamp
+	}
+}
+
+// inferAgentReadyLocked treats the agent as ready when a session is opened but the start of the agent was not read,
+// such as resuming from the middle of the log. Other lines of the agent do not mean that it can serve sessions.
+func (m *Monitor) inferAgentReadyLocked(e LogEntry) {
+	if (m.agent.Status != AgentStatusUnknown && m.agent.Status != AgentStatusLogNotFound) || !e.IsFromSessionWorker() || !e.IsSessionOpened() {
+		return
+	}
+	m.agent.Status = AgentStatusReady
+	m.agent.ReadyAt = e.Timestamp
+}
+
+func (m *Monitor) markAgentLogNotFound() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.agent.Status != AgentStatusUnknown {
+		return
+	}
+	m.logger().Warn("ssm agent log is not created within the agent ready timeout", "path", m.logFilePath, "timeout", m.opts.LogFileWaitTimeout)
+	m.agent.Status = AgentStatusLogNotFound
+}
+
+// CheckAgentProcess marks the agent as stopped when the amazon-ssm-agent process seen in /proc is gone,
+// which happens when the agent crashes without logging that it stops.
+func (m *Monitor) CheckAgentProcess() error {
+	running, err := isCoreAgentRunning()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if running {
+		m.agentProcessSeen = true
+		return nil
+	}
+	if !m.agentProcessSeen || m.agent.Status == AgentStatusStopped {
+		return nil
+	}
+	m.logger().Warn("amazon-ssm-agent process is gone")
+	m.agent.Status = AgentStatusStopped
+	m.agent.StoppedAt = flextime.Now()
+	return nil
+}
+
+// AgentHealth returns the health of the SSM agent read from the log.
+func (m *Monitor) AgentHealth() AgentHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.agent
+}
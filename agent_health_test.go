@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor__AgentHealth(t *testing.T) {
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader("2023-11-17 07:08:48 INFO [amazon-ssm-agent] Starting Core Agent\n")))
+	agent := m.AgentHealth()
+	require.Equal(t, AgentStatusStarting, agent.Status)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 8, 48, 0, time.UTC), agent.StartedAt)
+
+	logs := "2023-11-17 07:08:49 INFO [ssm-agent-worker] [MessageService] [MGSInteractor] Setting up websocket for controlchannel\n"
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	require.Equal(t, AgentStatusStarting, m.AgentHealth().Status, "not ready without the readiness message")
+
+	logs = "2023-11-17 07:08:51 INFO [ssm-agent-worker] [MessageService] processor initialization completed for worker ssm-session-worker belonging to MGSInteractor\n"
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	agent = m.AgentHealth()
+	require.Equal(t, AgentStatusReady, agent.Status)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 8, 51, 0, time.UTC), agent.ReadyAt)
+
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader("2023-11-17 07:20:00 INFO [amazon-ssm-agent] Stopping Core Agent\n")))
+	agent = m.AgentHealth()
+	require.Equal(t, AgentStatusStopped, agent.Status)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 20, 0, 0, time.UTC), agent.StoppedAt)
+
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader("2023-11-17 07:20:05 INFO [amazon-ssm-agent] Starting Core Agent\n")))
+	agent = m.AgentHealth()
+	require.Equal(t, AgentStatusStarting, agent.Status)
+	require.Equal(t, 1, agent.Restarts)
+	require.Equal(t, 0, m.Metrics().TotalConnections)
+}
+
+func TestMonitor__AgentHealthFromHistory(t *testing.T) {
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader("2023-11-17 07:40:28 INFO [ssm-session-worker] [doc-1] Session worker parameters\n")))
+	agent := m.AgentHealth()
+	require.Equal(t, AgentStatusReady, agent.Status)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 40, 28, 0, time.UTC), agent.ReadyAt)
+}
+
+func TestMonitor__AgentHealthNotReadyWithoutSession(t *testing.T) {
+	m := NewMonitor("")
+	logs := "2023-11-17 07:40:28 INFO [ssm-agent-worker] [MessageService] [MGSInteractor] Setting up websocket for controlchannel\n"
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	require.Equal(t, AgentStatusUnknown, m.AgentHealth().Status)
+}
+
+func TestMonitor__AgentLogNotFound(t *testing.T) {
+	m := NewMonitorWithOptions(filepath.Join(t.TempDir(), "amazon-ssm-agent.log"), MonitorOptions{LogFileWaitTimeout: 100 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	require.NoError(t, m.Run(ctx), "keeps waiting for the log file")
+	require.Equal(t, AgentStatusLogNotFound, m.AgentHealth().Status)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+// AnalyzeCLI is the flags of the analyze subcommand.
+type AnalyzeCLI struct {
+	Logs                     []string `arg:"" help:"Archived SSM Agent Logs, one per task, gzipped logs are supported" type:"existingfile"`
+	Format                   string   `help:"Output format" enum:"text,json" default:"text"`
+	Percentile               float64  `help:"Percentile of gaps between sessions and time to first session covered by the recommended timeouts" default:"95"`
+	SSMAgentLogTimezone      string   `help:"Timezone of SSM Agent Log timestamps, such as UTC or Asia/Tokyo (default: local timezone)" env:"ECS_TST_SSM_AGENT_LOG_TIMEZONE"`
+	LogParser                string   `help:"SSM Agent Log parser profile" enum:"agent-v3-text,agent-json,custom" default:"agent-v3-text" env:"ECS_TST_LOG_PARSER"`
+	LogParserRegex           string   `help:"Regex of custom log parser, with named groups Timestamp, LogLevel, DocumentID and Message" env:"ECS_TST_LOG_PARSER_REGEX"`
+	LogParserTimestampLayout string   `help:"Go time layout of the Timestamp group of custom log parser (default: 2006-01-02 15:04:05)" env:"ECS_TST_LOG_PARSER_TIMESTAMP_LAYOUT"`
+	LogParserOpenMarker      string   `help:"Message that marks a session as opened for custom log parser, any line opens a session if empty" env:"ECS_TST_LOG_PARSER_OPEN_MARKER"`
+	LogParserCloseMarker     string   `help:"Message that marks a session as closed for custom log parser (default: session worker closed)" env:"ECS_TST_LOG_PARSER_CLOSE_MARKER"`
+}
+
+func (cli *AnalyzeCLI) Parse(args []string) error {
+	parsed, err := kong.New(
+		cli,
+		kong.Name("ecs-task-self-terminator analyze"),
+		kong.Description("Report sessions of archived SSM Agent Logs and recommend timeouts"),
+		kong.UsageOnError(),
+		kong.ConfigureHelp(kong.HelpOptions{
+			Summary: true,
+			Compact: true,
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to parse CLI: %w", err)
+	}
+	_, err = parsed.Parse(args)
+	if err != nil {
+		return fmt.Errorf("failed to parse Args: %w", err)
+	}
+	return nil
+}
+
+// CLI returns the flags of the main command with the log parser of cli and the defaults of the main command, to simulate the recommended timeouts.
+func (cli *AnalyzeCLI) CLI() CLI {
+	return CLI{
+		SSMAgentLogTimezone:      cli.SSMAgentLogTimezone,
+		LogParser:                cli.LogParser,
+		LogParserRegex:           cli.LogParserRegex,
+		LogParserTimestampLayout: cli.LogParserTimestampLayout,
+		LogParserOpenMarker:      cli.LogParserOpenMarker,
+		LogParserCloseMarker:     cli.LogParserCloseMarker,
+		LogLevel:                 slog.LevelError,
+		IdleTimeout:              15 * time.Minute,
+		AgentReadyTimeout:        5 * time.Minute,
+		AgentFailurePolicy:       AgentFailurePolicyTerminate,
+		InitialWaitFrom:          InitialWaitFromStart,
+		ActivityMode:             "any",
+		MetricsCheckInterval:     time.Second,
+	}
+}
+
+type AnalyzeReport struct {
+	Tasks              int                   `json:"tasks"`
+	Sessions           int                   `json:"sessions"`
+	OpenSessions       int                   `json:"open_sessions"`
+	SessionsByType     map[SessionType]int   `json:"sessions_by_type"`
+	SessionDuration    DurationStats         `json:"session_duration"`
+	GapBetweenSessions DurationStats         `json:"gap_between_sessions"`
+	TimeToFirstSession DurationStats         `json:"time_to_first_session"`
+	Recommendation     AnalyzeRecommendation `json:"recommendation"`
+}
+
+// DurationStats are percentiles of durations in seconds.
+type DurationStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_seconds"`
+	P90   float64 `json:"p90_seconds"`
+	P95   float64 `json:"p95_seconds"`
+	P99   float64 `json:"p99_seconds"`
+	Max   float64 `json:"max_seconds"`
+}
+
+// AnalyzeRecommendation are the recommended timeouts, zero if there is not enough data.
+type AnalyzeRecommendation struct {
+	InitialWaitTime     time.Duration `json:"-"`
+	IdleTimeout         time.Duration `json:"-"`
+	MaxLifeTime         time.Duration `json:"-"`
+	InitialWaitSeconds  float64       `json:"initial_wait_time_seconds"`
+	IdleTimeoutSeconds  float64       `json:"idle_timeout_seconds"`
+	MaxLifeTimeSeconds  float64       `json:"max_life_time_seconds"`
+	ObservedTaskHours   float64       `json:"observed_task_hours"`
+	EstimatedHoursSaved float64       `json:"estimated_task_hours_saved"`
+	SessionsMissed      int           `json:"sessions_missed"`
+}
+
+// analyzedTask is the sessions reconstructed from the log of a task.
+type analyzedTask struct {
+	path     string
+	startAt  time.Time
+	endAt    time.Time
+	sessions []analyzedSession
+	gaps     []time.Duration
+}
+
+type analyzedSession struct {
+	documentID  string
+	sessionType SessionType
+	startedAt   time.Time
+	closedAt    time.Time
+	open        bool
+}
+
+// RunAnalyze writes a report of sessions in archived SSM Agent Logs, and the recommended timeouts.
+func RunAnalyze(ctx context.Context, w io.Writer, cli AnalyzeCLI) error {
+	if cli.Percentile <= 0 || cli.Percentile > 100 {
+		return fmt.Errorf("percentile must be in (0, 100]: %v", cli.Percentile)
+	}
+	mainCLI := cli.CLI()
+	cfg, err := mainCLI.LogParserConfig()
+	if err != nil {
+		return err
+	}
+	parser, err := NewLogParser(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create log parser: %w", err)
+	}
+	tasks := make([]*analyzedTask, 0, len(cli.Logs))
+	for _, path := range cli.Logs {
+		task, err := analyzeLogFile(ctx, path, parser)
+		if err != nil {
+			return err
+		}
+		if task != nil {
+			tasks = append(tasks, task)
+		}
+	}
+	if len(tasks) == 0 {
+		return errors.New("no log entries to analyze")
+	}
+	report := newAnalyzeReport(tasks, cli.Percentile)
+	if err := estimateSavings(ctx, mainCLI, tasks, &report.Recommendation); err != nil {
+		return err
+	}
+	if cli.Format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.WriteText(w)
+}
+
+func analyzeLogFile(ctx context.Context, path string, parser LogParser) (*analyzedTask, error) {
+	m := NewMonitorWithOptions(path, MonitorOptions{Parser: parser})
+	events, unsubscribe := m.Subscribe(simulateEventBuffer)
+	defer unsubscribe()
+	task := &analyzedTask{path: path}
+	index := map[string]int{}
+	open := map[string]bool{}
+	var idleSince time.Time
+	handle := func(event SessionEvent) {
+		switch event.Type {
+		case SessionEventStarted:
+			index[event.DocumentID] = len(task.sessions)
+			task.sessions = append(task.sessions, analyzedSession{
+				documentID:  event.DocumentID,
+				sessionType: event.SessionType,
+				startedAt:   event.Time,
+			})
+			if len(open) == 0 && !idleSince.IsZero() {
+				task.gaps = append(task.gaps, event.Time.Sub(idleSince))
+			}
+			open[event.DocumentID] = true
+			idleSince = time.Time{}
+		case SessionEventClosed:
+			i, ok := index[event.DocumentID]
+			if !ok {
+				return
+			}
+			task.sessions[i].closedAt = event.Time
+			delete(open, event.DocumentID)
+			if len(open) == 0 {
+				idleSince = event.Time
+			}
+		}
+	}
+	err := replayLogFile(ctx, path, parser, func(e LogEntry) bool {
+		if task.startAt.IsZero() {
+			task.startAt = e.Timestamp
+		}
+		if e.Timestamp.After(task.endAt) {
+			task.endAt = e.Timestamp
+		}
+		m.mark(e)
+		for drained := false; !drained; {
+			select {
+			case event := <-events:
+				handle(event)
+			default:
+				drained = true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if task.startAt.IsZero() {
+		return nil, nil
+	}
+	for i := range task.sessions {
+		if task.sessions[i].closedAt.IsZero() {
+			task.sessions[i].open = true
+			task.sessions[i].closedAt = task.endAt
+		}
+	}
+	return task, nil
+}
+
+func newAnalyzeReport(tasks []*analyzedTask, percentile float64) *AnalyzeReport {
+	report := &AnalyzeReport{
+		Tasks:          len(tasks),
+		SessionsByType: map[SessionType]int{},
+	}
+	var durations, gaps, firsts []time.Duration
+	var longest time.Duration
+	for _, task := range tasks {
+		gaps = append(gaps, task.gaps...)
+		for i, session := range task.sessions {
+			report.Sessions++
+			report.SessionsByType[session.sessionType]++
+			if session.open {
+				report.OpenSessions++
+			}
+			durations = append(durations, session.closedAt.Sub(session.startedAt))
+			if i == 0 {
+				firsts = append(firsts, session.startedAt.Sub(task.startAt))
+			}
+			if d := session.closedAt.Sub(task.startAt); d > longest {
+				longest = d
+			}
+		}
+	}
+	report.SessionDuration = newDurationStats(durations)
+	report.GapBetweenSessions = newDurationStats(gaps)
+	report.TimeToFirstSession = newDurationStats(firsts)
+	recommendation := &report.Recommendation
+	if len(firsts) > 0 {
+		recommendation.InitialWaitTime = roundUp(percentileOf(firsts, percentile), time.Minute)
+	}
+	if len(gaps) > 0 {
+		recommendation.IdleTimeout = roundUp(percentileOf(gaps, percentile), time.Minute)
+	}
+	if longest > 0 {
+		recommendation.MaxLifeTime = roundUp(longest, time.Hour)
+	}
+	recommendation.InitialWaitSeconds = recommendation.InitialWaitTime.Seconds()
+	recommendation.IdleTimeoutSeconds = recommendation.IdleTimeout.Seconds()
+	recommendation.MaxLifeTimeSeconds = recommendation.MaxLifeTime.Seconds()
+	return report
+}
+
+// estimateSavings simulates each task with the recommended timeouts, and sums the time the task would have been stopped earlier.
+func estimateSavings(ctx context.Context, cli CLI, tasks []*analyzedTask, recommendation *AnalyzeRecommendation) error {
+	if recommendation.IdleTimeout > 0 {
+		cli.IdleTimeout = recommendation.IdleTimeout
+	}
+	cli.InitialWaitTime = recommendation.InitialWaitTime
+	cli.MaxLifeTime = recommendation.MaxLifeTime
+	var observed, saved time.Duration
+	for _, task := range tasks {
+		observed += task.endAt.Sub(task.startAt)
+		// each task starts with a fresh App, not to carry the start time and the agent failure of the previous task.
+		app, err := newApp(cli)
+		if err != nil {
+			return err
+		}
+		sim, err := app.simulate(ctx, io.Discard, []string{task.path})
+		if err != nil {
+			return err
+		}
+		if sim.reason == "" || !sim.lastTick.Before(task.endAt) {
+			continue
+		}
+		saved += task.endAt.Sub(sim.lastTick)
+		for _, session := range task.sessions {
+			if session.startedAt.After(sim.lastTick) {
+				recommendation.SessionsMissed++
+			}
+		}
+	}
+	recommendation.ObservedTaskHours = observed.Hours()
+	recommendation.EstimatedHoursSaved = saved.Hours()
+	return nil
+}
+
+func newDurationStats(durations []time.Duration) DurationStats {
+	if len(durations) == 0 {
+		return DurationStats{}
+	}
+	return DurationStats{
+		Count: len(durations),
+		P50:   percentileOf(durations, 50).Seconds(),
+		P90:   percentileOf(durations, 90).Seconds(),
+		P95:   percentileOf(durations, 95).Seconds(),
+		P99:   percentileOf(durations, 99).Seconds(),
+		Max:   percentileOf(durations, 100).Seconds(),
+	}
+}
+
+// percentileOf returns the nearest-rank percentile of durations.
+func percentileOf(durations []time.Duration, percentile float64) time.Duration {
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func roundUp(d, unit time.Duration) time.Duration {
+	if rounded := d.Truncate(unit); rounded < d || rounded == 0 {
+		return rounded + unit
+	}
+	return d
+}
+
+func (report *AnalyzeReport) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "analyzed %d tasks, %d sessions (%d still open at the end of the log)\n", report.Tasks, report.Sessions, report.OpenSessions)
+	types := make([]string, 0, len(report.SessionsByType))
+	for sessionType := range report.SessionsByType {
+		types = append(types, string(sessionType))
+	}
+	sort.Strings(types)
+	for _, sessionType := range types {
+		fmt.Fprintf(w, "  %s: %d\n", sessionType, report.SessionsByType[SessionType(sessionType)])
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tCOUNT\tP50\tP90\tP95\tP99\tMAX\t")
+	for _, row := range []struct {
+		name  string
+		stats DurationStats
+	}{
+		{"session duration", report.SessionDuration},
+		{"gap between sessions", report.GapBetweenSessions},
+		{"time to first session", report.TimeToFirstSession},
+	} {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n", row.name, row.stats.Count,
+			secondsDuration(row.stats.P50), secondsDuration(row.stats.P90), secondsDuration(row.stats.P95), secondsDuration(row.stats.P99), secondsDuration(row.stats.Max))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	recommendation := report.Recommendation
+	fmt.Fprintln(w, "recommended flags:")
+	for _, flag := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"--initial-wait-time", recommendation.InitialWaitTime},
+		{"--idle-timeout", recommendation.IdleTimeout},
+		{"--max-life-time", recommendation.MaxLifeTime},
+	} {
+		if flag.value == 0 {
+			fmt.Fprintf(w, "  %s: not enough sessions to recommend\n", flag.name)
+			continue
+		}
+		fmt.Fprintf(w, "  %s=%s\n", flag.name, flag.value)
+	}
+	_, err := fmt.Fprintf(w, "estimated task hours saved: %.2f of %.2f observed, sessions missed: %d\n",
+		recommendation.EstimatedHoursSaved, recommendation.ObservedTaskHours, recommendation.SessionsMissed)
+	return err
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
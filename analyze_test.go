@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAnalyze(t *testing.T) {
+	var buf bytes.Buffer
+	cli := AnalyzeCLI{
+		Logs:                []string{"testdata/amazon-ssm-agent.log"},
+		Format:              "json",
+		Percentile:          95,
+		SSMAgentLogTimezone: "UTC",
+		LogParser:           LogParserAgentV3Text,
+	}
+	require.NoError(t, RunAnalyze(context.Background(), &buf, cli))
+	var report AnalyzeReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	require.Equal(t, 1, report.Tasks)
+	require.Equal(t, 4, report.Sessions)
+	require.Equal(t, 1, report.OpenSessions)
+	require.Equal(t, map[SessionType]int{SessionTypeExec: 3, SessionTypePortForward: 1}, report.SessionsByType)
+	require.Equal(t, 3, report.GapBetweenSessions.Count)
+	require.Equal(t, 1016.0, report.GapBetweenSessions.Max)
+	require.Equal(t, 60.0, report.TimeToFirstSession.P50)
+	require.Equal(t, 60.0, report.Recommendation.InitialWaitSeconds)
+	require.Equal(t, 1020.0, report.Recommendation.IdleTimeoutSeconds)
+	require.Equal(t, 3600.0, report.Recommendation.MaxLifeTimeSeconds)
+	require.Equal(t, 0.0, report.Recommendation.EstimatedHoursSaved)
+}
+
+func TestRunAnalyze__Savings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "amazon-ssm-agent.log")
+	logs := strings.Join([]string{
+		"2023-11-17 07:00:00 INFO [amazon-ssm-agent] Starting Core Agent",
+		"2023-11-17 07:00:05 INFO [ssm-agent-worker] [MessageService] processor initialization completed for worker ssm-session-worker belonging to MGSInteractor",
+		"2023-11-17 07:01:00 INFO [ssm-session-worker] [ecs-execute-command-1] Session worker parameters",
+		"2023-11-17 07:05:00 INFO [ssm-session-worker] [ecs-execute-command-1] Session worker closed",
+		"2023-11-17 07:10:00 INFO [ssm-session-worker] [ecs-execute-command-2] Session worker parameters",
+		"2023-11-17 07:20:00 INFO [ssm-session-worker] [ecs-execute-command-2] Session worker closed",
+		"2023-11-17 09:00:00 INFO [amazon-ssm-agent] Stopping Core Agent",
+	}, "\n") + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(logs), 0644))
+	var buf bytes.Buffer
+	cli := AnalyzeCLI{
+		Logs:                []string{path},
+		Format:              "text",
+		Percentile:          95,
+		SSMAgentLogTimezone: "UTC",
+		LogParser:           LogParserAgentV3Text,
+	}
+	require.NoError(t, RunAnalyze(context.Background(), &buf, cli))
+	require.Contains(t, buf.String(), "analyzed 1 tasks, 2 sessions (0 still open at the end of the log)")
+	require.Contains(t, buf.String(), "--initial-wait-time=1m0s")
+	require.Contains(t, buf.String(), "--idle-timeout=5m0s")
+	require.Contains(t, buf.String(), "--max-life-time=1h0m0s")
+	// stopped at 07:25:01 by the idle timeout instead of 09:00:00.
+	require.Contains(t, buf.String(), "estimated task hours saved: 1.58 of 2.00 observed, sessions missed: 0")
+}
+
+func TestRunAnalyze__AgentRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "amazon-ssm-agent.log")
+	logs := strings.Join([]string{
+		"2023-11-17 07:00:00 INFO [amazon-ssm-agent] Starting Core Agent",
+		"2023-11-17 07:00:05 INFO [ssm-agent-worker] [MessageService] processor initialization completed for worker ssm-session-worker belonging to MGSInteractor",
+		"2023-11-17 07:01:00 INFO [ssm-session-worker] [ecs-execute-command-1] Session worker parameters",
+		"2023-11-17 07:20:00 INFO [ssm-session-worker] [ecs-execute-command-1] Session worker closed",
+		"2023-11-17 07:30:00 INFO [amazon-ssm-agent] Stopping Core Agent",
+		"2023-11-17 07:31:00 INFO [amazon-ssm-agent] Starting Core Agent",
+		"2023-11-17 07:31:05 INFO [ssm-agent-worker] [MessageService] processor initialization completed for worker ssm-session-worker belonging to MGSInteractor",
+		"2023-11-17 07:40:00 INFO [ssm-session-worker] [ecs-execute-command-2] Session worker parameters",
+		"2023-11-17 07:50:00 INFO [ssm-session-worker] [ecs-execute-command-2] Session worker closed",
+		"2023-11-17 09:00:00 INFO [amazon-ssm-agent] Stopping Core Agent",
+	}, "\n") + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(logs), 0644))
+	var buf bytes.Buffer
+	cli := AnalyzeCLI{
+		Logs:                []string{path, path},
+		Format:              "text",
+		Percentile:          95,
+		SSMAgentLogTimezone: "UTC",
+		LogParser:           LogParserAgentV3Text,
+	}
+	require.NoError(t, RunAnalyze(context.Background(), &buf, cli))
+	require.Contains(t, buf.String(), "analyzed 2 tasks, 4 sessions")
+	// the restart of the agent within the agent ready timeout does not stop the task.
+	require.Contains(t, buf.String(), "sessions missed: 0")
+}
+
+func TestAnalyzeCLI__CLI(t *testing.T) {
+	var defaults CLI
+	require.NoError(t, defaults.Parse(nil))
+	cli := (&AnalyzeCLI{}).CLI()
+	require.Equal(t, defaults.IdleTimeout, cli.IdleTimeout)
+	require.Equal(t, defaults.AgentReadyTimeout, cli.AgentReadyTimeout)
+	require.Equal(t, defaults.AgentFailurePolicy, cli.AgentFailurePolicy)
+	require.Equal(t, defaults.InitialWaitFrom, cli.InitialWaitFrom)
+	require.Equal(t, defaults.ActivityMode, cli.ActivityMode)
+	require.Equal(t, defaults.MetricsCheckInterval, cli.MetricsCheckInterval)
+}
+
+func TestPercentileOf(t *testing.T) {
+	durations := []time.Duration{4 * time.Second, time.Second, 3 * time.Second, 2 * time.Second}
+	require.Equal(t, 2*time.Second, percentileOf(durations, 50))
+	require.Equal(t, 4*time.Second, percentileOf(durations, 95))
+	require.Equal(t, time.Second, percentileOf(durations, 1))
+	require.Equal(t, time.Minute, roundUp(time.Second, time.Minute))
+	require.Equal(t, time.Minute, roundUp(time.Minute, time.Minute))
+	require.Equal(t, 2*time.Minute, roundUp(61*time.Second, time.Minute))
+}
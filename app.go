@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -35,6 +36,16 @@ type App struct {
 	ecsMeta    *ECSMeta
 	httpClient *http.Client
 	ecsClient  ECSClient
+	logParser  LogParser
+	// closeSignals are enabled by --session-close-signals, DefaultCloseSignals if empty.
+	closeSignals []CloseSignal
+	journal      *SessionJournal
+	// monitor is the log source, which knows the health of the SSM agent.
+	monitor         *Monitor
+	agentFailure    string
+	agentFailureErr error
+	// activitySources are combined with the connection source by --activity-mode.
+	activitySources []ActivitySource
 }
 
 type ECSClient interface {
@@ -44,6 +55,68 @@ type ECSClient interface {
 }
 
 func New(cli CLI) (*App, error) {
+	app, err := newApp(cli)
+	if err != nil {
+		return nil, err
+	}
+	if len(cli.SessionSources) > 0 && !slices.Contains(cli.SessionSources, SessionSourceLog) {
+		if cli.SessionJournal != "" {
+			return nil, errors.New("--session-journal requires the log session source")
+		}
+		if cli.InitialWaitFrom == InitialWaitFromAgentReady {
+			return nil, errors.New("--initial-wait-from=agent-ready requires the log session source")
+		}
+	}
+	logger := app.logger
+	activityLogs, err := ParseActivityLogs(cli.ActivityLogs, cli.TailMode, logger)
+	if err != nil {
+		return nil, err
+	}
+	activitySources := make([]ActivitySource, 0, len(activityLogs))
+	for _, source := range activityLogs {
+		activitySources = append(activitySources, source)
+	}
+	if len(cli.ActivityTCPPorts) > 0 {
+		interval := cli.MetricsCheckInterval
+		if interval == 0 {
+			interval = 1 * time.Second
+		}
+		source, err := NewTCPActivitySource(cli.ActivityTCPPorts, cli.ActivityTCPExcludeCIDRs, interval, logger)
+		if err != nil {
+			return nil, err
+		}
+		activitySources = append(activitySources, source)
+	}
+	probeInterval := cli.ActivityProbeInterval
+	if probeInterval == 0 {
+		probeInterval = 30 * time.Second
+	}
+	if cli.ActivityHTTPProbe != "" {
+		activitySources = append(activitySources, NewHTTPProbeActivitySource(cli.ActivityHTTPProbe, cli.ActivityHTTPProbeJSONPath, probeInterval, http.DefaultClient, logger))
+	}
+	if cli.ActivityExecProbe != "" {
+		activitySources = append(activitySources, NewExecProbeActivitySource(cli.ActivityExecProbe, probeInterval, logger))
+	}
+	if cli.ActivityCPUThreshold != 0 || cli.ActivityNetworkThreshold != 0 {
+		source, err := NewTaskStatsActivitySource(os.Getenv("ECS_CONTAINER_METADATA_URI_V4"), cli.ActivityCPUThreshold, cli.ActivityNetworkThreshold, probeInterval, http.DefaultClient, logger)
+		if err != nil {
+			return nil, err
+		}
+		activitySources = append(activitySources, source)
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	app.httpClient = http.DefaultClient
+	app.ecsClient = ecs.NewFromConfig(awsCfg)
+	app.activitySources = activitySources
+	return app, nil
+}
+
+// newApp creates an App with the log parser and the logic of the main loop, without the activity sources and the clients of AWS,
+// which is enough to replay logs.
+func newApp(cli CLI) (*App, error) {
 	if cli.InitialWaitTime == 0 {
 		cli.InitialWaitTime = cli.IdleTimeout
 	}
@@ -103,17 +176,24 @@ func New(cli CLI) (*App, error) {
 		},
 	)
 	logger := slog.New(middleware)
-	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	logParserConfig, err := cli.LogParserConfig()
+	if err != nil {
+		return nil, err
+	}
+	logParser, err := NewLogParser(logParserConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log parser: %w", err)
+	}
+	closeSignals, err := ParseCloseSignals(cli.SessionCloseSignals)
 	if err != nil {
 		return nil, err
 	}
-
 	return &App{
-		cli:        cli,
-		logger:     logger,
-		startAt:    flextime.Now(),
-		httpClient: http.DefaultClient,
-		ecsClient:  ecs.NewFromConfig(awsCfg),
+		cli:          cli,
+		logger:       logger,
+		startAt:      flextime.Now(),
+		logParser:    logParser,
+		closeSignals: closeSignals,
 	}, nil
 }
 
@@ -137,10 +217,15 @@ func (app *App) Run(ctx context.Context) error {
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	state, err := app.loadState(ctx)
+	if err != nil {
+		return err
+	}
 	if app.cli.MaxLifeTime > 0 {
-		app.logger.DebugContext(ctx, "setting max lifetime", "maxLifeTime", app.cli.MaxLifeTime)
+		remaining := app.cli.MaxLifeTime - flextime.Since(app.startAt)
+		app.logger.DebugContext(ctx, "setting max lifetime", "maxLifeTime", app.cli.MaxLifeTime, "remaining", remaining)
 		var timeoutCancel context.CancelFunc
-		ctx, timeoutCancel = context.WithTimeout(ctx, app.cli.MaxLifeTime)
+		ctx, timeoutCancel = context.WithTimeout(ctx, remaining)
 		defer timeoutCancel()
 	}
 	if len(app.cli.Commands) > 0 {
@@ -162,18 +247,39 @@ func (app *App) Run(ctx context.Context) error {
 			execCancel(err)
 		}()
 	}
-	m := NewMonitor(app.cli.SSMAgentLogLocation)
+	if app.cli.SessionJournal != "" {
+		journal, err := OpenSessionJournal(app.cli.SessionJournal)
+		if err != nil {
+			return fmt.Errorf("failed to open session journal: %w", err)
+		}
+		app.journal = journal
+		defer app.finishJournal(ctx)
+	}
+	source, err := app.newConnectionSource(state)
+	if err != nil {
+		return err
+	}
 	go func() {
-		app.logger.DebugContext(ctx, "starting monitor", "logFilePath", app.cli.SSMAgentLogLocation)
-		if err := m.Run(ctx); err != nil {
+		app.logger.DebugContext(ctx, "starting session sources", "sessionSources", app.cli.SessionSources, "logFilePath", app.cli.SSMAgentLogLocation, "tailMode", app.cli.TailMode)
+		if err := source.Run(ctx); err != nil {
 			app.logger.ErrorContext(ctx, "monitor error", "error", err)
 			cancel()
 		}
 	}()
-	if str := app.mainLoop(ctx, cancel, m); app.stopReason == "" {
+	for _, activity := range app.activitySources {
+		go func(activity ActivitySource) {
+			if err := activity.Run(ctx); err != nil {
+				app.logger.WarnContext(ctx, "activity source error, disabled", "error", err)
+			}
+		}(activity)
+	}
+	if str := app.mainLoop(ctx, cancel, source); app.stopReason == "" {
 		app.stopReason = str
 	}
 	wg.Wait()
+	if app.agentFailureErr != nil {
+		return app.agentFailureErr
+	}
 	if err := context.Cause(ctx); err != nil {
 		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
 			return err
@@ -185,6 +291,56 @@ func (app *App) Run(ctx context.Context) error {
 	return nil
 }
 
+func (app *App) finishJournal(ctx context.Context) {
+	task := TaskJournalRecord{
+		StartAt:    app.startAt,
+		StopAt:     flextime.Now(),
+		StopReason: app.stopReason,
+	}
+	if app.ecsMeta != nil {
+		task.Cluster = app.ecsMeta.Cluster
+	}
+	task.TaskARN = app.taskARN()
+	if err := app.journal.Finish(task); err != nil {
+		app.logger.ErrorContext(ctx, "failed to write session journal", "error", err)
+	}
+	if err := app.journal.Close(); err != nil {
+		app.logger.ErrorContext(ctx, "failed to close session journal", "error", err)
+	}
+}
+
+// loadState loads --state-file of this task, and resumes the start time from it.
+func (app *App) loadState(ctx context.Context) (*State, error) {
+	if app.cli.StateFile == "" {
+		return nil, nil
+	}
+	state, err := LoadState(app.cli.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+	if state == nil {
+		return nil, nil
+	}
+	// a state file on a shared volume may be left by a previous task.
+	if state.TaskARN != app.taskARN() {
+		app.logger.WarnContext(ctx, "ignoring state file of another task", "stateFile", app.cli.StateFile, "state_task_arn", state.TaskARN, "task_arn", app.taskARN())
+		return nil, nil
+	}
+	if !state.StartAt.IsZero() {
+		app.logger.InfoContext(ctx, "resuming from state file", "stateFile", app.cli.StateFile, "start_at", state.StartAt, "updated_at", state.UpdatedAt)
+		app.startAt = state.StartAt
+	}
+	return state, nil
+}
+
+// taskARN returns the ARN of the running task, empty outside of ECS.
+func (app *App) taskARN() string {
+	if app.ecsMeta == nil {
+		return ""
+	}
+	return app.ecsMeta.TaskARN
+}
+
 func (app *App) StopReason() string {
 	return app.stopReason
 }
@@ -193,7 +349,86 @@ func (app *App) IsActive() bool {
 	return atomic.LoadInt32(&app.isActive) == 1
 }
 
-func (app *App) mainLoop(ctx context.Context, cancel context.CancelFunc, m *Monitor) string {
+func (app *App) newConnectionSource(state *State) (ConnectionSource, error) {
+	names := app.cli.SessionSources
+	if len(names) == 0 {
+		names = []string{SessionSourceLog}
+	}
+	sources := make([]ConnectionSource, 0, len(names))
+	var monitor *Monitor
+	var channels *ScanSource
+	for _, name := range names {
+		switch name {
+		case SessionSourceLog:
+			m := NewMonitorWithOptions(app.cli.SSMAgentLogLocation, MonitorOptions{
+				TailMode:                     app.cli.TailMode,
+				Parser:                       app.logParser,
+				Logger:                       app.logger,
+				ClockSkewThreshold:           app.cli.ClockSkewThreshold,
+				HistoryGlob:                  app.cli.SSMAgentLogHistory,
+				LogFileWaitTimeout:           app.cli.AgentReadyTimeout,
+				StateFile:                    app.cli.StateFile,
+				TaskARN:                      app.taskARN(),
+				StartAt:                      app.startAt,
+				CloseSignals:                 app.closeSignals,
+				SessionCheckInterval:         app.cli.MetricsCheckInterval,
+				CheckSessionProcess:          app.cli.CheckSessionProcess,
+				SessionInactivityTimeout:     app.cli.SessionInactivityTimeout,
+				MaxSessionDuration:           app.cli.MaxSessionDuration,
+				SessionKillInactivityTimeout: app.cli.SessionKillInactivityTimeout,
+				CheckAgentProcess:            true,
+			})
+			if state != nil {
+				m.Restore(state)
+			}
+			if app.journal != nil {
+				app.journal.Watch(m)
+			}
+			app.monitor = m
+			monitor = m
+			sources = append(sources, m)
+		case SessionSourceProc:
+			sources = append(sources, NewProcSource(app.cli.MetricsCheckInterval))
+		case SessionSourceChannels:
+			channels = NewChannelsSource(app.cli.SSMAgentChannelsGlob, app.cli.MetricsCheckInterval)
+			sources = append(sources, channels)
+		case SessionSourceHTTPProxy:
+			proxy, err := NewHTTPProxySource(app.cli.HTTPProxyListen, app.cli.HTTPProxyTarget, app.cli.HTTPProxyExcludePaths, app.logger)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, proxy)
+		case SessionSourceTCPProxy:
+			proxy, err := NewTCPProxySource(app.cli.TCPProxyListen, app.cli.TCPProxyTarget, app.logger)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, proxy)
+		default:
+			return nil, fmt.Errorf("unknown session source: %s", name)
+		}
+	}
+	if monitor != nil && channels != nil {
+		channels.OnScan(monitor.ReconcileChannels)
+	}
+	return newCrossCheckedSource(names, sources, app.logger), nil
+}
+
+// loopState is what the main loop waits for, decided from the metrics of the connection source.
+type loopState string
+
+const (
+	loopStateWaitingAgent loopState = "waiting-agent"
+	loopStateInitialWait  loopState = "initial-wait"
+	loopStateIdle         loopState = "idle"
+	loopStateInactive     loopState = "inactive"
+	loopStateActive       loopState = "active"
+	// loopStateActivity is kept by recent activity of activity sources, while it would be stopped otherwise.
+	loopStateActivity loopState = "activity"
+	loopStateStopped  loopState = "stopped"
+)
+
+func (app *App) mainLoop(ctx context.Context, cancel context.CancelFunc, m ConnectionSource) string {
 	app.logger.DebugContext(ctx, "starting main loop")
 	defer func() {
 		cancel()
@@ -207,37 +442,255 @@ func (app *App) mainLoop(ctx context.Context, cancel context.CancelFunc, m *Moni
 		default:
 			time.Sleep(app.cli.MetricsCheckInterval)
 		}
-		metrics := m.Metrics()
-		sinceLastConnections := time.Duration(0)
-		if !metrics.LastTimestamp.IsZero() {
-			sinceLastConnections = flextime.Since(metrics.LastTimestamp)
-		}
-		metricsAttr := slog.Group("metrics",
-			slog.Int("total_connections", metrics.TotalConnections),
-			slog.Int("active_connections", metrics.ActiveConnections),
-			slog.Duration("since_connections", sinceLastConnections),
-			slog.Any("last_timestamp", metrics.LastTimestamp),
-		)
-		app.logger.DebugContext(ctx, "monitor metrics", metricsAttr)
-
-		if metrics.TotalConnections == 0 {
-			app.logVervose(ctx, "no total connections", "start_at", app.startAt, "since_start_at", flextime.Since(app.startAt), metricsAttr)
-			if flextime.Since(app.startAt) > app.cli.InitialWaitTime {
-				app.logger.InfoContext(ctx, "no total connections after initial wait time")
-				return "no total connections after initial wait time"
+		if state, reason := app.check(ctx, m); state == loopStateStopped {
+			return reason
+		}
+	}
+}
+
+// checkMetrics decides the state of the main loop from the metrics of the connection source, and the reason to stop the task if the state is stopped.
+func (app *App) checkMetrics(ctx context.Context, metrics Metrics) (loopState, string) {
+	sinceLastConnections := time.Duration(0)
+	if !metrics.LastTimestamp.IsZero() {
+		sinceLastConnections = flextime.Since(metrics.LastTimestamp)
+	}
+	metricsAttr := slog.Group("metrics",
+		slog.Int("total_connections", metrics.TotalConnections),
+		slog.Int("active_connections", metrics.ActiveConnections),
+		slog.Int("inactive_connections", metrics.InactiveConnections),
+		slog.Duration("since_connections", sinceLastConnections),
+		slog.Any("last_timestamp", metrics.LastTimestamp),
+	)
+	app.logger.DebugContext(ctx, "monitor metrics", metricsAttr)
+
+	var agent AgentHealth
+	if app.monitor != nil {
+		agent = app.monitor.AgentHealth()
+	}
+	if metrics.TotalConnections == 0 {
+		initialWaitStart := app.startAt
+		if app.cli.InitialWaitFrom == InitialWaitFromAgentReady {
+			if agent.ReadyAt.IsZero() {
+				app.logVervose(ctx, "waiting for ssm agent to be ready before initial wait time", "agent_status", agent.Status)
+				return loopStateWaitingAgent, ""
 			}
-			continue
+			initialWaitStart = agent.ReadyAt
+		}
+		initialWaitTime := app.initialWaitTime()
+		app.logVervose(ctx, "no total connections", "start_at", initialWaitStart, "since_start_at", flextime.Since(initialWaitStart), "initial_wait_time", initialWaitTime, metricsAttr)
+		if flextime.Since(initialWaitStart) > initialWaitTime {
+			return loopStateStopped, "no total connections after initial wait time"
+		}
+		return loopStateInitialWait, ""
+	}
+	if metrics.ActiveConnections == 0 {
+		app.logVervose(ctx, "no active connections", metricsAttr)
+		if app.isIdleTimeoutExceeded(metrics) {
+			return loopStateStopped, "no active connections after idle timeout"
 		}
-		if metrics.ActiveConnections == 0 {
-			app.logVervose(ctx, "no active connections", metricsAttr)
-			if flextime.Since(metrics.LastTimestamp) > app.cli.IdleTimeout {
-				app.logger.InfoContext(ctx, "no active connections after idle timeout")
-				return "no active connections after idle timeout"
+		return loopStateIdle, ""
+	}
+	if metrics.ActiveConnections == metrics.InactiveConnections {
+		app.logVervose(ctx, "all active connections are inactive", metricsAttr)
+		if app.isIdleTimeoutExceeded(metrics) {
+			return loopStateStopped, "all active connections are inactive after session inactivity timeout"
+		}
+		return loopStateInactive, ""
+	}
+	app.logVervose(ctx, "has active connections", metricsAttr)
+	return loopStateActive, ""
+}
+
+// check decides the state of the main loop from the health of the SSM agent, the connection source and the activity sources.
+func (app *App) check(ctx context.Context, source ConnectionSource) (loopState, string) {
+	if app.monitor != nil {
+		if reason, stop := app.handleAgentFailure(ctx, app.monitor.AgentHealth()); stop {
+			return loopStateStopped, reason
+		}
+	}
+	metrics := source.Metrics()
+	state, reason := app.checkMetrics(ctx, metrics)
+	if len(app.activitySources) == 0 {
+		if state == loopStateStopped {
+			app.logger.InfoContext(ctx, reason)
+		}
+		return state, reason
+	}
+	activities := make([]sourceActivity, 0, 1+len(app.activitySources))
+	activities = append(activities, sourceActivity{
+		name:         source.Name(),
+		lastActivity: lastActivityOf(metrics),
+		idle:         state == loopStateStopped,
+		reason:       reason,
+	})
+	attrs := []any{slog.Bool(source.Name(), state != loopStateStopped)}
+	for _, activity := range app.activitySources {
+		a := app.checkActivity(activity)
+		activities = append(activities, a)
+		attrs = append(attrs, slog.Bool(a.name, !a.idle))
+	}
+	app.logger.DebugContext(ctx, "activity sources", slog.String("mode", app.cli.ActivityMode), slog.Group("active", attrs...))
+	if decided, stop := combineActivities(app.cli.ActivityMode, activities); stop {
+		reason := decided.name + ": " + decided.reason
+		app.logger.InfoContext(ctx, reason)
+		return loopStateStopped, reason
+	}
+	if state == loopStateStopped {
+		app.logVervose(ctx, "kept by activity sources", slog.String("mode", app.cli.ActivityMode))
+		return loopStateActivity, ""
+	}
+	return state, ""
+}
+
+// sourceActivity is the state of a source at a check of the main loop.
+type sourceActivity struct {
+	name         string
+	lastActivity time.Time
+	idle         bool
+	reason       string
+}
+
+// checkActivity decides whether an activity source is idle, a source without any activity is idle after the initial wait time.
+func (app *App) checkActivity(source ActivitySource) sourceActivity {
+	a := sourceActivity{name: source.Name(), lastActivity: source.LastActivity()}
+	if a.lastActivity.IsZero() {
+		if flextime.Since(app.startAt) > app.initialWaitTime() {
+			a.idle, a.reason = true, "no activity after initial wait time"
+		}
+	} else if flextime.Since(a.lastActivity) > app.cli.IdleTimeout {
+		a.idle, a.reason = true, "no activity after idle timeout"
+	}
+	return a
+}
+
+// combineActivities reports whether the task should be stopped, and the source that decided it.
+// In the any mode the task is stopped when all sources are idle, decided by the source idle last.
+// In the all mode the task is stopped when any source is idle, decided by the first idle source.
+func combineActivities(mode string, activities []sourceActivity) (sourceActivity, bool) {
+	if mode == ActivityModeAll {
+		for _, a := range activities {
+			if a.idle {
+				return a, true
 			}
+		}
+		return sourceActivity{}, false
+	}
+	var decided sourceActivity
+	for i, a := range activities {
+		if !a.idle {
+			return sourceActivity{}, false
+		}
+		if i == 0 || a.lastActivity.After(decided.lastActivity) {
+			decided = a
+		}
+	}
+	return decided, true
+}
+
+// handleAgentFailure applies --agent-failure-policy, and reports whether the main loop should stop.
+func (app *App) handleAgentFailure(ctx context.Context, agent AgentHealth) (string, bool) {
+	reason := app.agentFailureReason(agent)
+	defer func() {
+		app.agentFailure = reason
+	}()
+	if reason == "" {
+		if app.agentFailure != "" {
+			app.logger.InfoContext(ctx, "ssm agent recovered", "agent_status", agent.Status)
+		}
+		return "", false
+	}
+	switch app.cli.AgentFailurePolicy {
+	case AgentFailurePolicyKeepRunning:
+		if reason != app.agentFailure {
+			app.logger.WarnContext(ctx, reason+", keep running", "agent_status", agent.Status)
+		}
+		return "", false
+	case AgentFailurePolicyExitError:
+		app.logger.ErrorContext(ctx, reason, "agent_status", agent.Status)
+		app.agentFailureErr = errors.New(reason)
+		return reason, true
+	default:
+		app.logger.WarnContext(ctx, reason, "agent_status", agent.Status)
+		return reason, true
+	}
+}
+
+func (app *App) agentFailureReason(agent AgentHealth) string {
+	timeout := app.cli.AgentReadyTimeout
+	switch agent.Status {
+	case AgentStatusReady:
+		return ""
+	case AgentStatusStopped:
+		if timeout > 0 && flextime.Since(agent.StoppedAt) > timeout {
+			return "ssm agent stopped"
+		}
+	case AgentStatusStarting:
+		if timeout > 0 && flextime.Since(agent.StartedAt) > timeout {
+			return "ssm agent is not ready after agent ready timeout"
+		}
+	case AgentStatusLogNotFound:
+		return "ssm agent log is not created after agent ready timeout"
+	}
+	// the status is unknown for logs without the agent lines, such as rotated logs and custom log parsers,
+	// so the task is left to the initial wait time and the idle timeout.
+	return ""
+}
+
+func (app *App) idleTimeout(sessionType SessionType) time.Duration {
+	switch sessionType {
+	case SessionTypeExec:
+		if app.cli.ExecIdleTimeout > 0 {
+			return app.cli.ExecIdleTimeout
+		}
+	case SessionTypePortForward:
+		if app.cli.PortForwardIdleTimeout > 0 {
+			return app.cli.PortForwardIdleTimeout
+		}
+	}
+	return app.cli.IdleTimeout
+}
+
+func (app *App) initialWaitTimeFor(sessionType SessionType) time.Duration {
+	switch sessionType {
+	case SessionTypeExec:
+		if app.cli.ExecInitialWaitTime > 0 {
+			return app.cli.ExecInitialWaitTime
+		}
+	case SessionTypePortForward:
+		if app.cli.PortForwardInitialWaitTime > 0 {
+			return app.cli.PortForwardInitialWaitTime
+		}
+	}
+	return app.cli.InitialWaitTime
+}
+
+// initialWaitTime returns the longest initial wait time of all session types, because any type of session may be the first one.
+func (app *App) initialWaitTime() time.Duration {
+	var longest time.Duration
+	for _, sessionType := range SessionTypes {
+		if d := app.initialWaitTimeFor(sessionType); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+func (app *App) isIdleTimeoutExceeded(metrics Metrics) bool {
+	if len(metrics.ByType) == 0 {
+		return flextime.Since(metrics.LastTimestamp) > app.cli.IdleTimeout
+	}
+	for sessionType, typeMetrics := range metrics.ByType {
+		if typeMetrics.TotalConnections == 0 {
 			continue
 		}
-		app.logVervose(ctx, "has active connections", metricsAttr)
+		if typeMetrics.ActiveConnections > typeMetrics.InactiveConnections {
+			return false
+		}
+		if flextime.Since(typeMetrics.LastTimestamp) <= app.idleTimeout(sessionType) {
+			return false
+		}
 	}
+	return true
 }
 
 func (app *App) postProcess(ctx context.Context) error {
@@ -5,8 +5,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -72,3 +74,230 @@ func TestApp(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "no active connections after idle timeout", app.StopReason())
 }
+
+func TestAppIsIdleTimeoutExceeded(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	app := &App{
+		cli: CLI{
+			IdleTimeout:            15 * time.Minute,
+			ExecIdleTimeout:        10 * time.Minute,
+			PortForwardIdleTimeout: 60 * time.Minute,
+		},
+	}
+	metrics := Metrics{
+		TotalConnections: 2,
+		LastTimestamp:    time.Date(2023, 11, 17, 7, 45, 0, 0, time.UTC),
+		ByType: map[SessionType]SessionTypeMetrics{
+			SessionTypeExec: {
+				TotalConnections: 1,
+				LastTimestamp:    time.Date(2023, 11, 17, 7, 45, 0, 0, time.UTC),
+			},
+		},
+	}
+	require.True(t, app.isIdleTimeoutExceeded(metrics), "exec idle timeout 10m exceeded")
+	metrics.ByType[SessionTypePortForward] = SessionTypeMetrics{
+		TotalConnections: 1,
+		LastTimestamp:    time.Date(2023, 11, 17, 7, 30, 0, 0, time.UTC),
+	}
+	require.False(t, app.isIdleTimeoutExceeded(metrics), "port-forward idle timeout 60m not exceeded")
+	metrics.ByType[SessionTypeUnknown] = SessionTypeMetrics{
+		TotalConnections: 1,
+		LastTimestamp:    time.Date(2023, 11, 17, 6, 0, 0, 0, time.UTC),
+	}
+	flextime.Fix(time.Date(2023, 11, 17, 8, 31, 0, 0, time.UTC))
+	require.True(t, app.isIdleTimeoutExceeded(metrics))
+	metrics.ByType[SessionTypeExec] = SessionTypeMetrics{
+		ActiveConnections:   1,
+		InactiveConnections: 1,
+		TotalConnections:    2,
+		LastTimestamp:       time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC),
+	}
+	require.True(t, app.isIdleTimeoutExceeded(metrics), "inactive exec session after exec idle timeout 10m")
+	metrics.ByType[SessionTypeExec] = SessionTypeMetrics{
+		ActiveConnections:   2,
+		InactiveConnections: 1,
+		TotalConnections:    2,
+		LastTimestamp:       time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC),
+	}
+	require.False(t, app.isIdleTimeoutExceeded(metrics), "one exec session is still active")
+}
+
+func TestAppInitialWaitTime(t *testing.T) {
+	app := &App{
+		cli: CLI{
+			InitialWaitTime:     15 * time.Minute,
+			ExecInitialWaitTime: 5 * time.Minute,
+		},
+	}
+	require.Equal(t, 15*time.Minute, app.initialWaitTime())
+	app.cli.PortForwardInitialWaitTime = 10 * time.Minute
+	require.Equal(t, 10*time.Minute, app.initialWaitTime())
+	app.cli.PortForwardInitialWaitTime = 60 * time.Minute
+	require.Equal(t, 60*time.Minute, app.initialWaitTime())
+}
+
+func TestAppLoadState(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	startAt := time.Date(2023, 11, 17, 7, 8, 48, 0, time.UTC)
+	oldTaskARN := "arn:aws:ecs:ap-northeast-1:123456789012:task/default/00000000000000000000000000000000"
+	require.NoError(t, (&State{TaskARN: oldTaskARN, StartAt: startAt}).Save(stateFile))
+	now := time.Date(2023, 11, 17, 9, 0, 0, 0, time.UTC)
+	app := &App{
+		cli:     CLI{StateFile: stateFile},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		startAt: now,
+		ecsMeta: &ECSMeta{TaskARN: "arn:aws:ecs:ap-northeast-1:123456789012:task/default/11111111111111111111111111111111"},
+	}
+	state, err := app.loadState(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, state, "a state of another task is discarded")
+	require.Equal(t, now, app.startAt)
+
+	app.ecsMeta.TaskARN = oldTaskARN
+	state, err = app.loadState(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	require.Equal(t, startAt, app.startAt)
+}
+
+func TestAppAgentFailure(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 7, 10, 0, 0, time.UTC))
+	defer restore()
+	app := &App{
+		cli: CLI{
+			AgentReadyTimeout:  5 * time.Minute,
+			AgentFailurePolicy: AgentFailurePolicyTerminate,
+		},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		startAt: time.Date(2023, 11, 17, 7, 0, 0, 0, time.UTC),
+	}
+	cases := []struct {
+		name   string
+		agent  AgentHealth
+		reason string
+	}{
+		{
+			name: "no agent lines",
+		},
+		{
+			name:  "starting",
+			agent: AgentHealth{Status: AgentStatusStarting, StartedAt: time.Date(2023, 11, 17, 7, 6, 0, 0, time.UTC)},
+		},
+		{
+			name:   "stuck in starting",
+			agent:  AgentHealth{Status: AgentStatusStarting, StartedAt: time.Date(2023, 11, 17, 7, 4, 0, 0, time.UTC)},
+			reason: "ssm agent is not ready after agent ready timeout",
+		},
+		{
+			name:  "ready",
+			agent: AgentHealth{Status: AgentStatusReady, ReadyAt: time.Date(2023, 11, 17, 7, 1, 0, 0, time.UTC)},
+		},
+		{
+			name:  "stopped recently",
+			agent: AgentHealth{Status: AgentStatusStopped, StoppedAt: time.Date(2023, 11, 17, 7, 9, 0, 0, time.UTC)},
+		},
+		{
+			name:   "stopped",
+			agent:  AgentHealth{Status: AgentStatusStopped, StoppedAt: time.Date(2023, 11, 17, 7, 4, 0, 0, time.UTC)},
+			reason: "ssm agent stopped",
+		},
+		{
+			name:   "log not found",
+			agent:  AgentHealth{Status: AgentStatusLogNotFound},
+			reason: "ssm agent log is not created after agent ready timeout",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.reason, app.agentFailureReason(c.agent))
+		})
+	}
+
+	ctx := context.Background()
+	stuck := AgentHealth{Status: AgentStatusStarting, StartedAt: time.Date(2023, 11, 17, 7, 4, 0, 0, time.UTC)}
+	app.cli.AgentFailurePolicy = AgentFailurePolicyKeepRunning
+	reason, stop := app.handleAgentFailure(ctx, stuck)
+	require.False(t, stop)
+	require.Empty(t, reason)
+	require.NoError(t, app.agentFailureErr)
+
+	app.cli.AgentFailurePolicy = AgentFailurePolicyExitError
+	reason, stop = app.handleAgentFailure(ctx, stuck)
+	require.True(t, stop)
+	require.Equal(t, "ssm agent is not ready after agent ready timeout", reason)
+	require.EqualError(t, app.agentFailureErr, reason)
+
+	// zero waits forever.
+	app.cli.AgentReadyTimeout = 0
+	require.Empty(t, app.agentFailureReason(AgentHealth{}))
+	require.Empty(t, app.agentFailureReason(stuck))
+	require.Empty(t, app.agentFailureReason(AgentHealth{Status: AgentStatusStopped, StoppedAt: time.Date(2023, 11, 17, 7, 4, 0, 0, time.UTC)}))
+}
+
+type staticActivitySource struct {
+	name         string
+	lastActivity time.Time
+}
+
+func (s staticActivitySource) Name() string {
+	return s.name
+}
+
+func (s staticActivitySource) Run(ctx context.Context) error {
+	return nil
+}
+
+func (s staticActivitySource) LastActivity() time.Time {
+	return s.lastActivity
+}
+
+func TestAppCheck__Activity(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	app := &App{
+		cli: CLI{
+			InitialWaitTime: 30 * time.Minute,
+			IdleTimeout:     15 * time.Minute,
+			ActivityMode:    ActivityModeAny,
+		},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		startAt: time.Date(2023, 11, 17, 7, 0, 0, 0, time.UTC),
+	}
+	ctx := context.Background()
+	idle := &staticSource{metrics: Metrics{TotalConnections: 1, LastTimestamp: time.Date(2023, 11, 17, 7, 30, 0, 0, time.UTC)}}
+	state, reason := app.check(ctx, idle)
+	require.Equal(t, loopStateStopped, state)
+	require.Equal(t, "no active connections after idle timeout", reason)
+	state, _ = app.check(ctx, &staticSource{})
+	require.Equal(t, loopStateStopped, state)
+
+	app.activitySources = []ActivitySource{
+		staticActivitySource{name: "never"},
+		staticActivitySource{name: "recent", lastActivity: time.Date(2023, 11, 17, 7, 50, 0, 0, time.UTC)},
+	}
+	state, reason = app.check(ctx, idle)
+	require.Equal(t, loopStateActivity, state)
+	require.Empty(t, reason)
+	state, _ = app.check(ctx, &staticSource{})
+	require.Equal(t, loopStateActivity, state)
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 5, 1, 0, time.UTC))
+	state, reason = app.check(ctx, idle)
+	require.Equal(t, loopStateStopped, state)
+	require.Equal(t, "recent: no activity after idle timeout", reason, "decided by the source idle last")
+
+	app.cli.ActivityMode = ActivityModeAll
+	active := &staticSource{metrics: Metrics{ActiveConnections: 1, TotalConnections: 1, LastTimestamp: time.Date(2023, 11, 17, 7, 30, 0, 0, time.UTC)}}
+	state, reason = app.check(ctx, active)
+	require.Equal(t, loopStateStopped, state)
+	require.Equal(t, "never: no activity after initial wait time", reason, "decided by the first idle source")
+
+	app.activitySources = app.activitySources[1:]
+	flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	state, _ = app.check(ctx, active)
+	require.Equal(t, loopStateActive, state)
+	state, reason = app.check(ctx, idle)
+	require.Equal(t, loopStateStopped, state)
+	require.Equal(t, "static: no active connections after idle timeout", reason)
+}
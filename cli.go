@@ -9,26 +9,94 @@ import (
 )
 
 type CLI struct {
-	SSMAgentLogLocation   string        `help:"SSM Agent Log Location" default:"/var/log/amazon/ssm/amazon-ssm-agent.log" env:"ECS_TST_SSM_AGENT_LOG_LOCATION" type:"path"`
-	LogFormat             string        `help:"Log format" enum:"json,text" default:"text" env:"ECS_TST_LOG_FORMAT"`
-	LogLevel              slog.Level    `help:"Log level" default:"info" env:"ECS_TST_LOG_LEVEL"`
-	InitialWaitTime       time.Duration `help:"Initial wait time before starting the first ECS Exec or Portforward session" env:"ECS_TST_INITIAL_WAIT_TIME"`
-	IdleTimeout           time.Duration `help:"If no ECS Exec sessions occur within the specified time duration, the application will automatically terminate the ECS Task" default:"15m" env:"ECS_TST_IDLE_TIMEOUT"`
-	MaxLifeTime           time.Duration `help:"Maximum time duration for ECS Task" env:"ECS_TST_MAX_LIFE_TIME"`
-	SetDesiredCountToZero bool          `help:"Set desired count to zero when stopping task" env:"ECS_TST_SET_DESIRED_COUNT_TO_ZERO"`
-	StopTaskOnExit        bool          `help:"Stop task when stopping task" env:"ECS_TST_STOP_TASK"`
-	KeepAliveTask         bool          `help:"Keep alive task when finished command" env:"ECS_TST_KEEP_ALIVE_TASK"`
-	MetricsCheckInterval  time.Duration `help:"Metrics check interval" default:"1s" env:"ECS_TST_METRICS_CHECK_INTERVAL"`
-	Commands              []string      `arg:"" optional:"" help:"Command to run, if set run as wrapper"`
-	Vervose               bool          `help:"log output verbose output" env:"ECS_TST_VERBOSE"`
-	ECSServiceName        string        `help:"ECS Service Name" env:"ECS_TST_ECS_SERVICE_NAME"`
+	SSMAgentLogLocation          string        `help:"SSM Agent Log Location" default:"/var/log/amazon/ssm/amazon-ssm-agent.log" env:"ECS_TST_SSM_AGENT_LOG_LOCATION" type:"path"`
+	SessionSources               []string      `help:"Sources to discover sessions, the first one decides and the others are cross-checked: log tails SSM Agent Log, proc scans ssm-session-worker processes, channels scans IPC channels of SSM Agent, http-proxy counts requests of --http-proxy-listen, tcp-proxy counts connections of --tcp-proxy-listen" enum:"log,proc,channels,http-proxy,tcp-proxy" default:"log" env:"ECS_TST_SESSION_SOURCES"`
+	SSMAgentChannelsGlob         string        `help:"Glob of SSM Agent IPC channels directories for the channels session source" default:"/var/lib/amazon/ssm/*/channels" env:"ECS_TST_SSM_AGENT_CHANNELS_GLOB"`
+	HTTPProxyListen              string        `name:"http-proxy-listen" help:"Address to listen for the http-proxy session source, such as :8080" env:"ECS_TST_HTTP_PROXY_LISTEN"`
+	HTTPProxyTarget              string        `name:"http-proxy-target" help:"URL of the application to reverse-proxy requests and WebSockets, such as http://127.0.0.1:8443" env:"ECS_TST_HTTP_PROXY_TARGET"`
+	HTTPProxyExcludePaths        []string      `name:"http-proxy-exclude-paths" help:"Paths of requests that are not counted as sessions, such as health checks, requests of ELB-HealthChecker are never counted" env:"ECS_TST_HTTP_PROXY_EXCLUDE_PATHS"`
+	TCPProxyListen               string        `name:"tcp-proxy-listen" help:"Address to listen for the tcp-proxy session source, such as :5432" env:"ECS_TST_TCP_PROXY_LISTEN"`
+	TCPProxyTarget               string        `name:"tcp-proxy-target" help:"Address of the upstream to forward TCP connections, such as 127.0.0.1:15432" env:"ECS_TST_TCP_PROXY_TARGET"`
+	SSMAgentLogHistory           string        `help:"Glob of rotated or gzipped SSM Agent Logs, read once before tailing SSM Agent Log Location" env:"ECS_TST_SSM_AGENT_LOG_HISTORY"`
+	SSMAgentLogTimezone          string        `help:"Timezone of SSM Agent Log timestamps, such as UTC or Asia/Tokyo (default: local timezone of the container)" env:"ECS_TST_SSM_AGENT_LOG_TIMEZONE"`
+	ClockSkewThreshold           time.Duration `help:"Warn when SSM Agent Log timestamps differ from the current time more than this duration" default:"5m" env:"ECS_TST_CLOCK_SKEW_THRESHOLD"`
+	LogParser                    string        `help:"SSM Agent Log parser profile" enum:"agent-v3-text,agent-json,custom" default:"agent-v3-text" env:"ECS_TST_LOG_PARSER"`
+	LogParserRegex               string        `help:"Regex of custom log parser, with named groups Timestamp, LogLevel, DocumentID and Message" env:"ECS_TST_LOG_PARSER_REGEX"`
+	LogParserTimestampLayout     string        `help:"Go time layout of the Timestamp group of custom log parser (default: 2006-01-02 15:04:05)" env:"ECS_TST_LOG_PARSER_TIMESTAMP_LAYOUT"`
+	LogParserOpenMarker          string        `help:"Message that marks a session as opened for custom log parser, any line opens a session if empty" env:"ECS_TST_LOG_PARSER_OPEN_MARKER"`
+	LogParserCloseMarker         string        `help:"Message that marks a session as closed for custom log parser (default: session worker closed)" env:"ECS_TST_LOG_PARSER_CLOSE_MARKER"`
+	CheckLogParser               bool          `help:"Report which log parser profile matches a sample of SSM Agent Log and exit"`
+	TailMode                     TailMode      `help:"How to wait for SSM Agent Log updates, auto uses inotify if available" enum:"auto,inotify,poll" default:"auto" env:"ECS_TST_TAIL_MODE"`
+	SessionCloseSignals          []string      `help:"Signals that close a session: session-worker-closed, terminate-requested, executer-closed, error, ipc-channel-removed, process-exited (default: all but error)" env:"ECS_TST_SESSION_CLOSE_SIGNALS"`
+	CheckSessionProcess          bool          `help:"Close sessions whose ssm-session-worker process is gone from /proc" env:"ECS_TST_CHECK_SESSION_PROCESS"`
+	ActivityLogs                 []string      `name:"activity-log" help:"Log file of the application to tail as PATH=REGEX, a written line matching REGEX is activity, any line if REGEX is omitted, repeatable" sep:"none" env:"ECS_TST_ACTIVITY_LOG"`
+	ActivityTCPPorts             []int         `name:"activity-tcp-ports" help:"Local TCP ports whose established connections are activity, read from /proc/net/tcp and /proc/net/tcp6" env:"ECS_TST_ACTIVITY_TCP_PORTS"`
+	ActivityTCPExcludeCIDRs      []string      `name:"activity-tcp-exclude-cidrs" help:"Remote CIDRs of TCP connections that are not activity, such as health checks of load balancers" env:"ECS_TST_ACTIVITY_TCP_EXCLUDE_CIDRS"`
+	ActivityHTTPProbe            string        `name:"activity-http-probe" help:"URL to poll every --activity-probe-interval whether the application is busy" env:"ECS_TST_ACTIVITY_HTTP_PROBE"`
+	ActivityHTTPProbeJSONPath    string        `name:"activity-http-probe-json-path" help:"JSON path of a boolean of busy or a timestamp of the last activity in the response of --activity-http-probe, such as last_activity, any 2xx response is busy if empty" env:"ECS_TST_ACTIVITY_HTTP_PROBE_JSON_PATH"`
+	ActivityExecProbe            string        `name:"activity-exec-probe" help:"Command run with sh every --activity-probe-interval whether the application is busy, exit 0 is activity" env:"ECS_TST_ACTIVITY_EXEC_PROBE"`
+	ActivityCPUThreshold         float64       `name:"activity-cpu-threshold" help:"CPU utilization of the containers in percent of one vCPU at or above which is activity, sampled from the task metadata stats (default: disabled)" env:"ECS_TST_ACTIVITY_CPU_THRESHOLD"`
+	ActivityNetworkThreshold     int64         `name:"activity-network-threshold" help:"Network bytes per second received and sent by the task at or above which is activity, sampled from the task metadata stats (default: disabled)" env:"ECS_TST_ACTIVITY_NETWORK_THRESHOLD"`
+	ActivityProbeInterval        time.Duration `help:"Interval and timeout of --activity-http-probe, --activity-exec-probe and sampling of the task metadata stats" default:"30s" env:"ECS_TST_ACTIVITY_PROBE_INTERVAL"`
+	ActivityMode                 string        `help:"How the session source and activity sources are combined: any keeps the task while any source is active, all stops the task when any source is idle" enum:"any,all" default:"any" env:"ECS_TST_ACTIVITY_MODE"`
+	LogFormat                    string        `help:"Log format" enum:"json,text" default:"text" env:"ECS_TST_LOG_FORMAT"`
+	LogLevel                     slog.Level    `help:"Log level" default:"info" env:"ECS_TST_LOG_LEVEL"`
+	InitialWaitTime              time.Duration `help:"Initial wait time before starting the first ECS Exec or Portforward session" env:"ECS_TST_INITIAL_WAIT_TIME"`
+	IdleTimeout                  time.Duration `help:"If no ECS Exec sessions occur within the specified time duration, the application will automatically terminate the ECS Task" default:"15m" env:"ECS_TST_IDLE_TIMEOUT"`
+	MaxLifeTime                  time.Duration `help:"Maximum time duration for ECS Task" env:"ECS_TST_MAX_LIFE_TIME"`
+	SessionInactivityTimeout     time.Duration `help:"Treat open ECS Exec sessions without input or output for this duration as idle (default: disabled)" env:"ECS_TST_SESSION_INACTIVITY_TIMEOUT"`
+	MaxSessionDuration           time.Duration `help:"Kill the ssm-session-worker of a session open longer than this duration, keeping the task and other sessions (default: disabled)" env:"ECS_TST_MAX_SESSION_DURATION"`
+	SessionKillInactivityTimeout time.Duration `help:"Kill the ssm-session-worker of a session without input or output for this duration, keeping the task and other sessions (default: disabled)" env:"ECS_TST_SESSION_KILL_INACTIVITY_TIMEOUT"`
+	ExecInitialWaitTime          time.Duration `help:"Initial wait time for ECS Exec sessions, overrides --initial-wait-time" env:"ECS_TST_EXEC_INITIAL_WAIT_TIME"`
+	ExecIdleTimeout              time.Duration `help:"Idle timeout after the last ECS Exec session, overrides --idle-timeout" env:"ECS_TST_EXEC_IDLE_TIMEOUT"`
+	PortForwardInitialWaitTime   time.Duration `help:"Initial wait time for Portforward sessions, overrides --initial-wait-time" env:"ECS_TST_PORT_FORWARD_INITIAL_WAIT_TIME"`
+	PortForwardIdleTimeout       time.Duration `help:"Idle timeout after the last Portforward session, overrides --idle-timeout" env:"ECS_TST_PORT_FORWARD_IDLE_TIMEOUT"`
+	AgentReadyTimeout            time.Duration `help:"Time to wait for the SSM agent log to be created and the agent to be ready, or to start again after it stopped, before --agent-failure-policy applies (0 to wait forever)" default:"5m" env:"ECS_TST_AGENT_READY_TIMEOUT"`
+	AgentFailurePolicy           string        `help:"What to do when the SSM agent never becomes ready or stops: terminate the task, keep running, or exit with an error" enum:"terminate,keep-running,exit-error" default:"terminate" env:"ECS_TST_AGENT_FAILURE_POLICY"`
+	InitialWaitFrom              string        `help:"When the initial wait time starts, at the start of the task or when the SSM agent becomes ready" enum:"start,agent-ready" default:"start" env:"ECS_TST_INITIAL_WAIT_FROM"`
+	SetDesiredCountToZero        bool          `help:"Set desired count to zero when stopping task" env:"ECS_TST_SET_DESIRED_COUNT_TO_ZERO"`
+	StopTaskOnExit               bool          `help:"Stop task when stopping task" env:"ECS_TST_STOP_TASK"`
+	KeepAliveTask                bool          `help:"Keep alive task when finished command" env:"ECS_TST_KEEP_ALIVE_TASK"`
+	MetricsCheckInterval         time.Duration `help:"Metrics check interval" default:"1s" env:"ECS_TST_METRICS_CHECK_INTERVAL"`
+	Commands                     []string      `arg:"" optional:"" help:"Command to run, if set run as wrapper"`
+	Vervose                      bool          `help:"log output verbose output" env:"ECS_TST_VERBOSE"`
+	SessionJournal               string        `help:"Path to append the audit journal of sessions as JSON Lines, - for stdout" env:"ECS_TST_SESSION_JOURNAL"`
+	StateFile                    string        `help:"Path to the state file to resume monitoring after restarts" env:"ECS_TST_STATE_FILE" type:"path"`
+	ECSServiceName               string        `help:"ECS Service Name" env:"ECS_TST_ECS_SERVICE_NAME"`
 }
 
-func (cli *CLI) Parse(args []string) error {
-	parsed, err := kong.New(
-		cli,
+func (cli *CLI) LogParserConfig() (LogParserConfig, error) {
+	loc, err := cli.SSMAgentLogTimeLocation()
+	if err != nil {
+		return LogParserConfig{}, err
+	}
+	return LogParserConfig{
+		Profile:         cli.LogParser,
+		Regex:           cli.LogParserRegex,
+		TimestampLayout: cli.LogParserTimestampLayout,
+		OpenMarker:      cli.LogParserOpenMarker,
+		CloseMarker:     cli.LogParserCloseMarker,
+		Location:        loc,
+	}, nil
+}
+
+func (cli *CLI) SSMAgentLogTimeLocation() (*time.Location, error) {
+	switch cli.SSMAgentLogTimezone {
+	case "", "Local":
+		return time.Local, nil
+	default:
+		loc, err := time.LoadLocation(cli.SSMAgentLogTimezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssm agent log timezone: %w", err)
+		}
+		return loc, nil
+	}
+}
+
+func (cli *CLI) Parse(args []string, options ...kong.Option) error {
+	options = append([]kong.Option{
 		kong.Name("ecs-task-self-terminator"),
-		kong.Description("ECS Task Self Terminator "+Version),
+		kong.Description("ECS Task Self Terminator " + Version),
 		kong.UsageOnError(),
 		kong.ConfigureHelp(kong.HelpOptions{
 			Summary: true,
@@ -37,7 +105,8 @@ func (cli *CLI) Parse(args []string) error {
 		kong.Vars{
 			"version": Version,
 		},
-	)
+	}, options...)
+	parsed, err := kong.New(cli, options...)
 	if err != nil {
 		return fmt.Errorf("failed to parse CLI: %w", err)
 	}
@@ -20,36 +20,66 @@ func TestCLIParse(t *testing.T) {
 			name: "default",
 			args: []string{"ecs-task-self-terminator"},
 			expected: CLI{
-				SSMAgentLogLocation:  "/var/log/amazon/ssm/amazon-ssm-agent.log",
-				LogFormat:            "text",
-				LogLevel:             slog.LevelInfo,
-				IdleTimeout:          15 * time.Minute,
-				MetricsCheckInterval: 1 * time.Second,
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "text",
+				LogLevel:              slog.LevelInfo,
+				IdleTimeout:           15 * time.Minute,
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
 			},
 		},
 		{
 			name: "initial-wait-time",
 			args: []string{"ecs-task-self-terminator", "--initial-wait-time", "1m"},
 			expected: CLI{
-				SSMAgentLogLocation:  "/var/log/amazon/ssm/amazon-ssm-agent.log",
-				LogFormat:            "text",
-				LogLevel:             slog.LevelInfo,
-				InitialWaitTime:      1 * time.Minute,
-				IdleTimeout:          15 * time.Minute,
-				MetricsCheckInterval: 1 * time.Second,
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "text",
+				LogLevel:              slog.LevelInfo,
+				InitialWaitTime:       1 * time.Minute,
+				IdleTimeout:           15 * time.Minute,
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
 			},
 		},
 		{
 			name: "as wrapper",
 			args: []string{"ecs-task-self-terminator", "--initial-wait-time", "1m", "--", "sleep", "1"},
 			expected: CLI{
-				SSMAgentLogLocation:  "/var/log/amazon/ssm/amazon-ssm-agent.log",
-				LogFormat:            "text",
-				LogLevel:             slog.LevelInfo,
-				InitialWaitTime:      1 * time.Minute,
-				IdleTimeout:          15 * time.Minute,
-				Commands:             []string{"sleep", "1"},
-				MetricsCheckInterval: 1 * time.Second,
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "text",
+				LogLevel:              slog.LevelInfo,
+				InitialWaitTime:       1 * time.Minute,
+				IdleTimeout:           15 * time.Minute,
+				Commands:              []string{"sleep", "1"},
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
 			},
 		},
 		{
@@ -62,12 +92,22 @@ func TestCLIParse(t *testing.T) {
 				"--idle-timeout", "5m",
 			},
 			expected: CLI{
-				SSMAgentLogLocation:  "/var/log/amazon/ssm/amazon-ssm-agent.log",
-				LogFormat:            "json",
-				LogLevel:             slog.LevelDebug,
-				InitialWaitTime:      1 * time.Minute,
-				IdleTimeout:          5 * time.Minute,
-				MetricsCheckInterval: 1 * time.Second,
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "json",
+				LogLevel:              slog.LevelDebug,
+				InitialWaitTime:       1 * time.Minute,
+				IdleTimeout:           5 * time.Minute,
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
 			},
 		},
 		{
@@ -77,12 +117,106 @@ func TestCLIParse(t *testing.T) {
 				"--max-life-time", "1h",
 			},
 			expected: CLI{
-				SSMAgentLogLocation:  "/var/log/amazon/ssm/amazon-ssm-agent.log",
-				LogFormat:            "text",
-				LogLevel:             slog.LevelInfo,
-				IdleTimeout:          15 * time.Minute,
-				MaxLifeTime:          1 * time.Hour,
-				MetricsCheckInterval: 1 * time.Second,
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "text",
+				LogLevel:              slog.LevelInfo,
+				IdleTimeout:           15 * time.Minute,
+				MaxLifeTime:           1 * time.Hour,
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
+			},
+		},
+		{
+			name: "per session type timeouts",
+			args: []string{
+				"ecs-task-self-terminator",
+				"--exec-idle-timeout", "10m",
+				"--port-forward-idle-timeout", "60m",
+				"--port-forward-initial-wait-time", "30m",
+			},
+			expected: CLI{
+				SSMAgentLogLocation:        "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:             []string{"log"},
+				SSMAgentChannelsGlob:       "/var/lib/amazon/ssm/*/channels",
+				TailMode:                   TailModeAuto,
+				LogParser:                  LogParserAgentV3Text,
+				ClockSkewThreshold:         5 * time.Minute,
+				LogFormat:                  "text",
+				LogLevel:                   slog.LevelInfo,
+				IdleTimeout:                15 * time.Minute,
+				ExecIdleTimeout:            10 * time.Minute,
+				PortForwardIdleTimeout:     60 * time.Minute,
+				PortForwardInitialWaitTime: 30 * time.Minute,
+				AgentReadyTimeout:          5 * time.Minute,
+				AgentFailurePolicy:         "terminate",
+				InitialWaitFrom:            "start",
+				ActivityProbeInterval:      30 * time.Second,
+				ActivityMode:               "any",
+				MetricsCheckInterval:       1 * time.Second,
+			},
+		},
+		{
+			name: "session close signals",
+			envs: map[string]string{
+				"ECS_TST_SESSION_CLOSE_SIGNALS": "session-worker-closed,ipc-channel-removed",
+			},
+			args: []string{"ecs-task-self-terminator"},
+			expected: CLI{
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				SessionCloseSignals:   []string{"session-worker-closed", "ipc-channel-removed"},
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "text",
+				LogLevel:              slog.LevelInfo,
+				IdleTimeout:           15 * time.Minute,
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
+			},
+		},
+		{
+			name: "activity logs",
+			args: []string{
+				"ecs-task-self-terminator",
+				"--activity-log=/var/log/nginx/access.log= (200|304) ",
+				"--activity-log=/var/log/pgbouncer.log=login attempt: db=\\w+,",
+				"--activity-tcp-ports=5432,6432",
+				"--activity-tcp-exclude-cidrs=10.0.0.0/24",
+			},
+			expected: CLI{
+				SSMAgentLogLocation:     "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:          []string{"log"},
+				SSMAgentChannelsGlob:    "/var/lib/amazon/ssm/*/channels",
+				TailMode:                TailModeAuto,
+				ActivityLogs:            []string{"/var/log/nginx/access.log= (200|304) ", "/var/log/pgbouncer.log=login attempt: db=\\w+,"},
+				ActivityTCPPorts:        []int{5432, 6432},
+				ActivityTCPExcludeCIDRs: []string{"10.0.0.0/24"},
+				LogParser:               LogParserAgentV3Text,
+				ClockSkewThreshold:      5 * time.Minute,
+				LogFormat:               "text",
+				LogLevel:                slog.LevelInfo,
+				IdleTimeout:             15 * time.Minute,
+				AgentReadyTimeout:       5 * time.Minute,
+				AgentFailurePolicy:      "terminate",
+				InitialWaitFrom:         "start",
+				ActivityProbeInterval:   30 * time.Second,
+				ActivityMode:            "any",
+				MetricsCheckInterval:    1 * time.Second,
 			},
 		},
 		{
@@ -98,13 +232,23 @@ func TestCLIParse(t *testing.T) {
 				"ecs-task-self-terminator",
 			},
 			expected: CLI{
-				SSMAgentLogLocation:  "/var/log/amazon/ssm/amazon-ssm-agent.log",
-				LogFormat:            "json",
-				LogLevel:             slog.LevelWarn,
-				InitialWaitTime:      1 * time.Minute,
-				IdleTimeout:          5 * time.Minute,
-				MaxLifeTime:          1 * time.Hour,
-				MetricsCheckInterval: 1 * time.Second,
+				SSMAgentLogLocation:   "/var/log/amazon/ssm/amazon-ssm-agent.log",
+				SessionSources:        []string{"log"},
+				SSMAgentChannelsGlob:  "/var/lib/amazon/ssm/*/channels",
+				TailMode:              TailModeAuto,
+				LogParser:             LogParserAgentV3Text,
+				ClockSkewThreshold:    5 * time.Minute,
+				LogFormat:             "json",
+				LogLevel:              slog.LevelWarn,
+				InitialWaitTime:       1 * time.Minute,
+				IdleTimeout:           5 * time.Minute,
+				MaxLifeTime:           1 * time.Hour,
+				AgentReadyTimeout:     5 * time.Minute,
+				AgentFailurePolicy:    "terminate",
+				InitialWaitFrom:       "start",
+				ActivityProbeInterval: 30 * time.Second,
+				ActivityMode:          "any",
+				MetricsCheckInterval:  1 * time.Second,
 			},
 		},
 	}
@@ -122,3 +266,19 @@ func TestCLIParse(t *testing.T) {
 		})
 	}
 }
+
+func TestCLISSMAgentLogTimeLocation(t *testing.T) {
+	cli := CLI{}
+	loc, err := cli.SSMAgentLogTimeLocation()
+	require.NoError(t, err)
+	require.Equal(t, time.Local, loc)
+
+	cli.SSMAgentLogTimezone = "Asia/Tokyo"
+	loc, err = cli.SSMAgentLogTimeLocation()
+	require.NoError(t, err)
+	require.Equal(t, "Asia/Tokyo", loc.String())
+
+	cli.SSMAgentLogTimezone = "Invalid/Zone"
+	_, err = cli.SSMAgentLogTimeLocation()
+	require.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// ConnectionSource discovers ECS Exec and Portforward sessions, and is the first activity source of the task.
+type ConnectionSource interface {
+	ActivitySource
+	Metrics() Metrics
+}
+
+// lastActivityOf is now while some active connections are not inactive, otherwise the last timestamp of the connections.
+func lastActivityOf(metrics Metrics) time.Time {
+	if metrics.ActiveConnections > metrics.InactiveConnections {
+		return flextime.Now()
+	}
+	return metrics.LastTimestamp
+}
+
+const (
+	SessionSourceLog       = "log"
+	SessionSourceProc      = "proc"
+	SessionSourceChannels  = "channels"
+	SessionSourceHTTPProxy = "http-proxy"
+	SessionSourceTCPProxy  = "tcp-proxy"
+)
+
+// crossCheckedSource reports the metrics of the first source, and warns when the other sources disagree on active connections.
+type crossCheckedSource struct {
+	names   []string
+	sources []ConnectionSource
+	logger  *slog.Logger
+
+	mu           sync.Mutex
+	disagreement bool
+}
+
+func newCrossCheckedSource(names []string, sources []ConnectionSource, logger *slog.Logger) ConnectionSource {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return &crossCheckedSource{
+		names:   names,
+		sources: sources,
+		logger:  logger,
+	}
+}
+
+func (s *crossCheckedSource) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(s.sources))
+	var wg sync.WaitGroup
+	for _, source := range s.sources {
+		wg.Add(1)
+		go func(source ConnectionSource) {
+			defer wg.Done()
+			if err := source.Run(ctx); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(source)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (s *crossCheckedSource) Name() string {
+	return s.names[0]
+}
+
+func (s *crossCheckedSource) LastActivity() time.Time {
+	return s.sources[0].LastActivity()
+}
+
+func (s *crossCheckedSource) Metrics() Metrics {
+	metrics := s.sources[0].Metrics()
+	attrs := []any{slog.Int(s.names[0], metrics.ActiveConnections)}
+	disagreement := false
+	for i, source := range s.sources[1:] {
+		active := source.Metrics().ActiveConnections
+		attrs = append(attrs, slog.Int(s.names[i+1], active))
+		if active != metrics.ActiveConnections {
+			disagreement = true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if disagreement != s.disagreement {
+		s.disagreement = disagreement
+		if disagreement {
+			s.logger.Warn("session sources disagree on active connections, using "+s.names[0], slog.Group("active_connections", attrs...))
+		} else {
+			s.logger.Info("session sources agree on active connections again", slog.Group("active_connections", attrs...))
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticSource struct {
+	metrics Metrics
+}
+
+func (s *staticSource) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s *staticSource) Name() string {
+	return "static"
+}
+
+func (s *staticSource) LastActivity() time.Time {
+	return lastActivityOf(s.metrics)
+}
+
+func (s *staticSource) Metrics() Metrics {
+	return s.metrics
+}
+
+func TestCrossCheckedSource(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	log := &staticSource{metrics: Metrics{ActiveConnections: 1, TotalConnections: 2}}
+	proc := &staticSource{metrics: Metrics{ActiveConnections: 1, TotalConnections: 1}}
+	source := newCrossCheckedSource([]string{SessionSourceLog, SessionSourceProc}, []ConnectionSource{log, proc}, logger)
+
+	require.Equal(t, log.metrics, source.Metrics())
+	require.Empty(t, buf.String())
+
+	proc.metrics.ActiveConnections = 0
+	require.Equal(t, log.metrics, source.Metrics())
+	require.Contains(t, buf.String(), "session sources disagree on active connections, using log")
+	require.Contains(t, buf.String(), "active_connections.log=1 active_connections.proc=0")
+	buf.Reset()
+	source.Metrics()
+	require.Empty(t, buf.String(), "warn only once")
+
+	log.metrics.ActiveConnections = 0
+	source.Metrics()
+	require.Contains(t, buf.String(), "session sources agree on active connections again")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, source.Run(ctx))
+}
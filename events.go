@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type SessionEventType string
+
+const (
+	SessionEventStarted        SessionEventType = "SessionStarted"
+	SessionEventClosed         SessionEventType = "SessionClosed"
+	SessionEventActivity       SessionEventType = "SessionActivity"
+	SessionEventAgentRestarted SessionEventType = "AgentRestarted"
+)
+
+// SessionEvent is emitted by Monitor to subscribers.
+// SessionStarted is emitted once the plugin config of the session is read, so SessionType and SessionOwner are known,
+// and SessionClosed is not emitted for weak signals, which may be followed by lines that reopen the session.
+type SessionEvent struct {
+	Type         SessionEventType `json:"type"`
+	Time         time.Time        `json:"time"`
+	DocumentID   string           `json:"document_id,omitempty"`
+	SessionType  SessionType      `json:"session_type,omitempty"`
+	SessionOwner string           `json:"session_owner,omitempty"`
+	StartedAt    *time.Time       `json:"started_at,omitempty"`
+	LastIO       *time.Time       `json:"last_io,omitempty"`
+	ClosedBy     CloseSignal      `json:"closed_by,omitempty"`
+}
+
+// Subscribe returns a channel of SessionEvents and a function to unsubscribe.
+// Events are dropped when the buffer of the channel is full, so that a slow subscriber does not block Monitor, use Handle not to lose events.
+func (m *Monitor) Subscribe(buffer int) (<-chan SessionEvent, func()) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	ch := make(chan SessionEvent, buffer)
+	m.subscribers[ch] = struct{}{}
+	return ch, func() {
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// SessionEventHandler is called by Monitor with the session info of the event.
+// It is called while Monitor is locked, so it must not call methods of Monitor.
+type SessionEventHandler func(event SessionEvent, info SessionInfo)
+
+// Handle registers a handler that is called synchronously and never misses events, unlike Subscribe.
+// The handler is called with SessionStarted of the sessions already open, such as sessions restored from the state file.
+func (m *Monitor) Handle(handler SessionEventHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subMu.Lock()
+	m.handlers = append(m.handlers, handler)
+	m.subMu.Unlock()
+	documentIDs := make([]string, 0, len(m.startPublished))
+	for documentID := range m.startPublished {
+		if !m.IsSessionWorkerClosed[documentID] {
+			documentIDs = append(documentIDs, documentID)
+		}
+	}
+	sort.Strings(documentIDs)
+	for _, documentID := range documentIDs {
+		handler(m.sessionEventLocked(SessionEventStarted, documentID), m.sessionInfos[documentID])
+	}
+}
+
+// publish is called while Monitor is locked.
+func (m *Monitor) publish(event SessionEvent) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for _, handler := range m.handlers {
+		handler(event, m.sessionInfos[event.DocumentID])
+	}
+	for ch := range m.subscribers {
+		select {
+		case ch <- event:
+		default:
+			m.logger().Warn("session event subscriber is too slow, dropped event", "type", event.Type, "document_id", event.DocumentID)
+		}
+	}
+}
+
+func (m *Monitor) publishSessionEventLocked(eventType SessionEventType, documentID string) {
+	m.publish(m.sessionEventLocked(eventType, documentID))
+}
+
+func (m *Monitor) sessionEventLocked(eventType SessionEventType, documentID string) SessionEvent {
+	event := SessionEvent{
+		Type:        eventType,
+		Time:        m.lastTimestamps[documentID],
+		DocumentID:  documentID,
+		SessionType: m.sessionTypeLocked(documentID),
+		ClosedBy:    m.closedBy[documentID],
+	}
+	if event.SessionType == SessionTypeUnknown {
+		event.SessionType = SessionTypeFromDocumentID(documentID)
+	}
+	if info, ok := m.sessionInfos[documentID]; ok {
+		event.SessionOwner = info.SessionOwner
+	}
+	if t, ok := m.startedAt[documentID]; ok {
+		event.StartedAt = &t
+		if eventType == SessionEventStarted {
+			event.Time = t
+		}
+	}
+	if t, ok := m.lastIO[documentID]; ok {
+		event.LastIO = &t
+		if eventType == SessionEventActivity {
+			event.Time = t
+		}
+	}
+	return event
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor__Subscribe(t *testing.T) {
+	file, err := os.Open("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	defer file.Close()
+	m := NewMonitor("")
+	events, unsubscribe := m.Subscribe(100)
+	require.NoError(t, m.RunWithReader(context.Background(), file))
+	unsubscribe()
+
+	var got []SessionEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	types := make([]SessionEventType, 0, len(got))
+	for _, event := range got {
+		types = append(types, event.Type)
+	}
+	require.Equal(t, []SessionEventType{
+		SessionEventStarted, SessionEventClosed,
+		SessionEventStarted, SessionEventClosed,
+		SessionEventStarted, SessionEventClosed,
+		SessionEventStarted,
+	}, types)
+
+	require.Equal(t, SessionTypeExec, got[0].SessionType)
+	require.Equal(t, "arn:aws:sts::123456789012:assumed-role/AWSServiceRoleForECS/ecs-execute-command", got[0].SessionOwner, "published after the plugin config")
+	require.Equal(t, SessionTypePortForward, got[4].SessionType)
+	closed := got[5]
+	require.Equal(t, "aws-go-sdk-1700206823550536000-0749df7ec4fc89a00", closed.DocumentID)
+	require.Equal(t, SessionTypePortForward, closed.SessionType)
+	require.Equal(t, "arn:aws:sts::123456789012:assumed-role/KayacDeveloper/aws-go-sdk-1700206823550536000", closed.SessionOwner)
+	require.Equal(t, CloseSignalSessionWorkerClosed, closed.ClosedBy)
+	require.NotNil(t, closed.StartedAt)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 40, 28, 0, time.UTC), *closed.StartedAt)
+}
+
+func TestMonitor__SubscribeAgentRestarted(t *testing.T) {
+	logs := strings.Join([]string{
+		"2023-11-17 07:08:48 INFO [amazon-ssm-agent] Starting Core Agent",
+		"2023-11-17 07:09:48 INFO [ssm-session-worker] [doc-1] Session worker parameters",
+		"2023-11-17 07:10:48 INFO [amazon-ssm-agent] Starting Core Agent",
+	}, "\n") + "\n"
+	m := NewMonitor("")
+	events, unsubscribe := m.Subscribe(10)
+	defer unsubscribe()
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	// SessionStarted of doc-1 waits for its plugin config.
+	require.Equal(t, SessionEvent{
+		Type: SessionEventAgentRestarted,
+		Time: time.Date(2023, 11, 17, 7, 10, 48, 0, time.UTC),
+	}, <-events)
+	require.Empty(t, events)
+	require.Equal(t, 1, m.Metrics().TotalConnections)
+}
+
+func TestMonitor__SubscribeWeakClose(t *testing.T) {
+	logs := strings.Join([]string{
+		"2023-11-17 07:40:28 INFO [ssm-session-worker] [doc-1] Session worker parameters",
+		"2023-11-17 07:40:30 ERROR [ssm-session-worker] [doc-1] [DataBackend] [pluginName=Port] Unable to read from connection",
+		"2023-11-17 07:40:31 INFO [ssm-session-worker] [doc-1] [DataBackend] [pluginName=Port] Connection accepted",
+		"2023-11-17 07:40:40 INFO [ssm-session-worker] [doc-1] Session worker closed",
+	}, "\n") + "\n"
+	m := NewMonitorWithOptions("", MonitorOptions{CloseSignals: CloseSignals})
+	events, unsubscribe := m.Subscribe(10)
+	defer unsubscribe()
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	started := <-events
+	require.Equal(t, SessionEventStarted, started.Type, "published before SessionClosed without the plugin config")
+	require.Equal(t, time.Date(2023, 11, 17, 7, 40, 28, 0, time.UTC), started.Time)
+	closed := <-events
+	require.Equal(t, SessionEventClosed, closed.Type)
+	require.Equal(t, CloseSignalSessionWorkerClosed, closed.ClosedBy)
+	require.Empty(t, events, "the weak close is not published")
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func fileIDOf(os.FileInfo) FileID {
+	return FileID{}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const httpProxyShutdownTimeout = 5 * time.Second
+
+// HTTPProxySource reverse-proxies HTTP requests and WebSockets to the application, such as code-server or Jupyter behind ALB.
+// In-flight requests and open WebSockets are active connections, and the start and end of requests are the last timestamp.
+// Health checks of ALB and requests to excluded paths are proxied but not counted.
+type HTTPProxySource struct {
+	listen       string
+	excludePaths []string
+	proxy        *httputil.ReverseProxy
+	logger       *slog.Logger
+
+	mu      sync.RWMutex
+	metrics Metrics
+}
+
+func NewHTTPProxySource(listen string, target string, excludePaths []string, logger *slog.Logger) (*HTTPProxySource, error) {
+	if listen == "" || target == "" {
+		return nil, errors.New("http-proxy session source requires --http-proxy-listen and --http-proxy-target")
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid http proxy target: %s", target)
+	}
+	s := &HTTPProxySource{
+		listen:       listen,
+		excludePaths: excludePaths,
+		proxy:        httputil.NewSingleHostReverseProxy(u),
+		logger:       logger,
+	}
+	s.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if s.logger != nil {
+			s.logger.WarnContext(r.Context(), "http proxy error", "path", r.URL.Path, "error", err)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return s, nil
+}
+
+func (s *HTTPProxySource) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    s.listen,
+		Handler: s,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpProxyShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if s.logger != nil {
+		s.logger.InfoContext(ctx, "starting http proxy", "listen", s.listen)
+	}
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *HTTPProxySource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.counted(r) {
+		s.proxy.ServeHTTP(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.metrics.ActiveConnections++
+	s.metrics.TotalConnections++
+	s.metrics.LastTimestamp = flextime.Now()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.metrics.ActiveConnections--
+		s.metrics.LastTimestamp = flextime.Now()
+		s.mu.Unlock()
+	}()
+	// the upgraded connection of a WebSocket is proxied until it is closed.
+	s.proxy.ServeHTTP(w, r)
+}
+
+func (s *HTTPProxySource) counted(r *http.Request) bool {
+	if strings.HasPrefix(r.UserAgent(), "ELB-HealthChecker") {
+		return false
+	}
+	return !slices.Contains(s.excludePaths, r.URL.Path)
+}
+
+func (s *HTTPProxySource) Name() string {
+	return SessionSourceHTTPProxy
+}
+
+func (s *HTTPProxySource) LastActivity() time.Time {
+	return lastActivityOf(s.Metrics())
+}
+
+func (s *HTTPProxySource) Metrics() Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.metrics
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProxySource(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		fmt.Fprint(w, "ok "+r.URL.Path)
+	}))
+	defer backend.Close()
+	source, err := NewHTTPProxySource(":0", backend.URL, []string{"/healthz"}, nil)
+	require.NoError(t, err)
+	proxy := httptest.NewServer(source)
+	defer proxy.Close()
+
+	get := func(path string, userAgent string) string {
+		req, err := http.NewRequest(http.MethodGet, proxy.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		bs, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(bs)
+	}
+	require.Equal(t, "ok /healthz", get("/healthz", "curl"))
+	require.Equal(t, "ok /", get("/", "ELB-HealthChecker/2.0"))
+	require.Equal(t, Metrics{}, source.Metrics())
+
+	require.Equal(t, "ok /", get("/", "curl"))
+	metrics := source.Metrics()
+	require.Equal(t, 0, metrics.ActiveConnections)
+	require.Equal(t, 1, metrics.TotalConnections)
+	require.False(t, metrics.LastTimestamp.IsZero())
+
+	done := make(chan string)
+	go func() {
+		resp, err := http.Get(proxy.URL + "/slow")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		done <- string(bs)
+	}()
+	require.Eventually(t, func() bool {
+		return source.Metrics().ActiveConnections == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	close(release)
+	require.Equal(t, "ok /slow", <-done)
+	require.Equal(t, 0, source.Metrics().ActiveConnections)
+	require.Equal(t, 2, source.Metrics().TotalConnections)
+}
+
+func TestHTTPProxySource__WebSocket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(buf, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		buf.Flush()
+		// echo until the client closes the connection.
+		io.Copy(conn, buf)
+	}))
+	defer backend.Close()
+	source, err := NewHTTPProxySource(":0", backend.URL, nil, nil)
+	require.NoError(t, err)
+	proxy := httptest.NewServer(source)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	require.NoError(t, err)
+	fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	fmt.Fprint(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", line)
+	require.Equal(t, 1, source.Metrics().ActiveConnections)
+
+	conn.Close()
+	require.Eventually(t, func() bool {
+		return source.Metrics().ActiveConnections == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, source.Metrics().TotalConnections)
+}
+
+func TestNewHTTPProxySource(t *testing.T) {
+	_, err := NewHTTPProxySource("", "http://127.0.0.1:8443", nil, nil)
+	require.Error(t, err)
+	_, err = NewHTTPProxySource(":8080", "127.0.0.1:8443", nil, nil)
+	require.Error(t, err)
+}
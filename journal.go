@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionJournal is the audit log of sessions written as JSON Lines.
+// A session record is written when a session closes, and records of sessions still open and the task are written by Finish.
+type SessionJournal struct {
+	mu       sync.Mutex
+	w        io.Writer
+	closer   io.Closer
+	enc      *json.Encoder
+	open     map[string]journalSession
+	total    int
+	finished bool
+	err      error
+}
+
+type journalSession struct {
+	event SessionEvent
+	info  SessionInfo
+}
+
+type SessionJournalRecord struct {
+	Type            string      `json:"type"`
+	Status          string      `json:"status"`
+	DocumentID      string      `json:"document_id"`
+	DocumentName    string      `json:"document_name,omitempty"`
+	SessionType     SessionType `json:"session_type"`
+	SessionOwner    string      `json:"session_owner,omitempty"`
+	Command         string      `json:"command,omitempty"`
+	Host            string      `json:"host,omitempty"`
+	PortNumber      string      `json:"port_number,omitempty"`
+	LocalPortNumber string      `json:"local_port_number,omitempty"`
+	StartedAt       *time.Time  `json:"started_at,omitempty"`
+	ClosedAt        *time.Time  `json:"closed_at,omitempty"`
+	DurationSeconds float64     `json:"duration_seconds"`
+	ClosedBy        CloseSignal `json:"closed_by,omitempty"`
+}
+
+type TaskJournalRecord struct {
+	Type            string    `json:"type"`
+	Cluster         string    `json:"cluster,omitempty"`
+	TaskARN         string    `json:"task_arn,omitempty"`
+	StartAt         time.Time `json:"start_at"`
+	StopAt          time.Time `json:"stop_at"`
+	LifetimeSeconds float64   `json:"lifetime_seconds"`
+	StopReason      string    `json:"stop_reason"`
+	TotalSessions   int       `json:"total_sessions"`
+	OpenSessions    int       `json:"open_sessions"`
+}
+
+// OpenSessionJournal appends to the file at path, or writes to stdout if path is "-".
+func OpenSessionJournal(path string) (*SessionJournal, error) {
+	if path == "-" {
+		return NewSessionJournal(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := NewSessionJournal(f)
+	j.closer = f
+	return j, nil
+}
+
+func NewSessionJournal(w io.Writer) *SessionJournal {
+	return &SessionJournal{
+		w:    w,
+		enc:  json.NewEncoder(w),
+		open: map[string]journalSession{},
+	}
+}
+
+// Watch starts writing the sessions of the monitor, including the sessions restored from the state file.
+// The journal is written synchronously by the monitor, so no session is lost.
+func (j *SessionJournal) Watch(m *Monitor) {
+	m.Handle(j.handle)
+}
+
+func (j *SessionJournal) handle(event SessionEvent, info SessionInfo) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished {
+		return
+	}
+	switch event.Type {
+	case SessionEventStarted:
+		j.total++
+		j.open[event.DocumentID] = journalSession{event: event, info: info}
+	case SessionEventClosed:
+		if _, ok := j.open[event.DocumentID]; !ok {
+			// restored from the state file as closed by a weak signal.
+			j.total++
+		}
+		delete(j.open, event.DocumentID)
+		j.writeSessionLocked(event, info, "closed")
+	}
+}
+
+func (j *SessionJournal) writeSessionLocked(event SessionEvent, info SessionInfo, status string) {
+	record := SessionJournalRecord{
+		Type:            "session",
+		Status:          status,
+		DocumentID:      event.DocumentID,
+		DocumentName:    info.DocumentName,
+		SessionType:     event.SessionType,
+		SessionOwner:    event.SessionOwner,
+		Command:         info.Command,
+		Host:            info.Host,
+		PortNumber:      info.PortNumber,
+		LocalPortNumber: info.LocalPortNumber,
+		StartedAt:       event.StartedAt,
+		ClosedBy:        event.ClosedBy,
+	}
+	end := event.Time
+	if status == "closed" {
+		record.ClosedAt = &end
+	}
+	if record.StartedAt != nil {
+		record.DurationSeconds = end.Sub(*record.StartedAt).Seconds()
+	}
+	j.writeLocked(record)
+}
+
+func (j *SessionJournal) writeLocked(record any) {
+	if err := j.enc.Encode(record); err != nil && j.err == nil {
+		j.err = err
+	}
+}
+
+// Finish stops watching the monitor, writes the sessions still open and the task record.
+func (j *SessionJournal) Finish(task TaskJournalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished = true
+	for _, session := range sortedJournalSessions(j.open) {
+		session.event.Time = task.StopAt
+		j.writeSessionLocked(session.event, session.info, "open")
+	}
+	task.Type = "task"
+	task.LifetimeSeconds = task.StopAt.Sub(task.StartAt).Seconds()
+	task.TotalSessions = j.total
+	task.OpenSessions = len(j.open)
+	j.writeLocked(task)
+	return j.err
+}
+
+func sortedJournalSessions(sessions map[string]journalSession) []journalSession {
+	sorted := make([]journalSession, 0, len(sessions))
+	for _, session := range sessions {
+		sorted = append(sorted, session)
+	}
+	sort.Slice(sorted, func(i, k int) bool {
+		return sorted[i].event.DocumentID < sorted[k].event.DocumentID
+	})
+	return sorted
+}
+
+func (j *SessionJournal) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+	return j.closer.Close()
+}
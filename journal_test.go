@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionJournal(t *testing.T) {
+	file, err := os.Open("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	defer file.Close()
+	var buf bytes.Buffer
+	journal := NewSessionJournal(&buf)
+	m := NewMonitor("")
+	journal.Watch(m)
+	require.NoError(t, m.RunWithReader(context.Background(), file))
+	require.NoError(t, journal.Finish(TaskJournalRecord{
+		TaskARN:    "arn:aws:ecs:ap-northeast-1:123456789012:task/default/00000000000000000000000000000000",
+		StartAt:    time.Date(2023, 11, 17, 7, 8, 0, 0, time.UTC),
+		StopAt:     time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC),
+		StopReason: "no active connections after idle timeout",
+	}))
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 5)
+	require.Equal(t, map[string]any{
+		"type":              "session",
+		"status":            "closed",
+		"document_id":       "aws-go-sdk-1700206823550536000-0749df7ec4fc89a00",
+		"document_name":     "AWS-StartPortForwardingSession",
+		"session_type":      "port-forward",
+		"session_owner":     "arn:aws:sts::123456789012:assumed-role/KayacDeveloper/aws-go-sdk-1700206823550536000",
+		"port_number":       "80",
+		"local_port_number": "80",
+		"started_at":        "2023-11-17T07:40:28Z",
+		"closed_at":         "2023-11-17T07:40:32Z",
+		"duration_seconds":  4.0,
+		"closed_by":         "session-worker-closed",
+	}, records[2])
+	require.Equal(t, "open", records[3]["status"])
+	require.Equal(t, "ecs-execute-command-02f7755870b50f125", records[3]["document_id"])
+	require.Equal(t, "sh", records[3]["command"])
+	require.Nil(t, records[3]["closed_at"])
+	require.Equal(t, map[string]any{
+		"type":             "task",
+		"task_arn":         "arn:aws:ecs:ap-northeast-1:123456789012:task/default/00000000000000000000000000000000",
+		"start_at":         "2023-11-17T07:08:00Z",
+		"stop_at":          "2023-11-17T08:00:00Z",
+		"lifetime_seconds": 3120.0,
+		"stop_reason":      "no active connections after idle timeout",
+		"total_sessions":   4.0,
+		"open_sessions":    1.0,
+	}, records[4])
+}
+
+func TestSessionJournal__Restored(t *testing.T) {
+	startedAt := time.Date(2023, 11, 17, 7, 40, 0, 0, time.UTC)
+	m := NewMonitor("")
+	m.Restore(&State{
+		LastTimestamps: map[string]time.Time{
+			"doc-open":   startedAt,
+			"doc-closed": startedAt,
+		},
+		IsSessionWorkerClosed: map[string]bool{"doc-closed": true},
+		SessionInfos: map[string]SessionInfo{
+			"doc-open": {DocumentID: "doc-open", Type: SessionTypeExec, Command: "sh"},
+		},
+		StartedAt: map[string]time.Time{"doc-open": startedAt},
+	})
+	var buf bytes.Buffer
+	journal := NewSessionJournal(&buf)
+	journal.Watch(m)
+	require.NoError(t, journal.Finish(TaskJournalRecord{
+		StartAt: time.Date(2023, 11, 17, 7, 8, 0, 0, time.UTC),
+		StopAt:  time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC),
+	}))
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	require.Equal(t, "open", records[0]["status"])
+	require.Equal(t, "doc-open", records[0]["document_id"])
+	require.Equal(t, "sh", records[0]["command"])
+	require.Equal(t, 1200.0, records[0]["duration_seconds"])
+	require.Equal(t, 1.0, records[1]["open_sessions"])
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	LogParserAgentV3Text = "agent-v3-text"
+	LogParserAgentJSON   = "agent-json"
+	LogParserCustom      = "custom"
+)
+
+const defaultLogTimestampLayout = "2006-01-02 15:04:05"
+
+type LogParser interface {
+	Name() string
+	Parse(line string) (LogEntry, bool, error)
+}
+
+// LogMarkers are the message fragments that mark a session as opened or closed.
+// An empty Open marker means that any line of a session opens it.
+type LogMarkers struct {
+	Open  string
+	Close string
+}
+
+var defaultLogMarkers = LogMarkers{
+	Close: "session worker closed",
+}
+
+type LogParserConfig struct {
+	Profile         string
+	Regex           string
+	TimestampLayout string
+	OpenMarker      string
+	CloseMarker     string
+	// Location is the timezone of timestamps without zone information, UTC if nil.
+	Location *time.Location
+}
+
+func NewLogParser(cfg LogParserConfig) (LogParser, error) {
+	loc := cfg.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch cfg.Profile {
+	case LogParserAgentV3Text, "":
+		if loc == time.UTC {
+			return DefaultLogParser, nil
+		}
+		return &regexLogParser{
+			name:            LogParserAgentV3Text,
+			res:             logEntryRegexes,
+			timestampLayout: defaultLogTimestampLayout,
+			location:        loc,
+			markers:         defaultLogMarkers,
+		}, nil
+	case LogParserAgentJSON:
+		return &jsonLogParser{
+			name:     LogParserAgentJSON,
+			messages: agentMessageRegexes,
+			location: loc,
+			markers:  defaultLogMarkers,
+		}, nil
+	case LogParserCustom:
+		if cfg.Regex == "" {
+			return nil, errors.New("custom log parser requires a regex")
+		}
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid custom log parser regex: %w", err)
+		}
+		for _, name := range []string{"Timestamp", "DocumentID", "Message"} {
+			if re.SubexpIndex(name) < 0 {
+				return nil, fmt.Errorf("custom log parser regex must have named group %q", name)
+			}
+		}
+		layout := cfg.TimestampLayout
+		if layout == "" {
+			layout = defaultLogTimestampLayout
+		}
+		markers := LogMarkers{
+			Open:  cfg.OpenMarker,
+			Close: cfg.CloseMarker,
+		}
+		if markers.Close == "" {
+			markers.Close = defaultLogMarkers.Close
+		}
+		return &regexLogParser{
+			name:            LogParserCustom,
+			res:             []*regexp.Regexp{re},
+			timestampLayout: layout,
+			location:        loc,
+			markers:         markers,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown log parser profile: %s", cfg.Profile)
+	}
+}
+
+var DefaultLogParser LogParser = &regexLogParser{
+	name:            LogParserAgentV3Text,
+	res:             logEntryRegexes,
+	timestampLayout: defaultLogTimestampLayout,
+	location:        time.UTC,
+	markers:         defaultLogMarkers,
+}
+
+type regexLogParser struct {
+	name            string
+	res             []*regexp.Regexp
+	timestampLayout string
+	location        *time.Location
+	markers         LogMarkers
+}
+
+func (p *regexLogParser) Name() string {
+	return p.name
+}
+
+func (p *regexLogParser) Parse(line string) (LogEntry, bool, error) {
+	e := LogEntry{markers: &p.markers}
+	var re *regexp.Regexp
+	var matches []string
+	for _, re = range p.res {
+		if matches = re.FindStringSubmatch(line); matches != nil {
+			break
+		}
+	}
+	if matches == nil {
+		return e, false, nil
+	}
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "Timestamp":
+			t, err := time.ParseInLocation(p.timestampLayout, matches[i], p.location)
+			if err != nil {
+				return e, false, err
+			}
+			e.Timestamp = t.UTC()
+		case "LogLevel":
+			e.LogLevel = matches[i]
+		case "Component":
+			e.Component = matches[i]
+		case "DocumentID":
+			e.DocumentID = matches[i]
+		case "Message":
+			e.Message = matches[i]
+		}
+	}
+	return e, true, nil
+}
+
+// agentMessageRegexes match the message part of session lines, without timestamp and level.
+var agentMessageRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`^\[(?P<Component>ssm-session-worker)\] \[(?P<DocumentID>\S+)\] (?P<Extra>\[.*\] )?(?P<Message>.*)$`),
+	regexp.MustCompile(`^\[(?P<Component>ssm-agent-worker)\] (?:\[\w+\] )*\[BasicExecuter\] \[(?P<DocumentID>\S+)\] (?P<Message>.*)$`),
+	// only the readiness of the agent worker, other lines of ssm-agent-worker are not needed.
+	regexp.MustCompile(`^\[(?P<Component>ssm-agent-worker)\] (?P<Message>(?:\[\w+\] )*processor initialization completed for worker ssm-session-worker.*)$`),
+	regexp.MustCompile(`^\[(?P<Component>amazon-ssm-agent)\] (?P<Message>.*)$`),
+}
+
+// jsonLogParser parses SSM Agent logs written by a JSON seelog format, such as
+// {"time":"2023-11-17T07:09:48Z","level":"INFO","msg":"[ssm-session-worker] [ecs-execute-command-xxx] Session worker closed"}
+type jsonLogParser struct {
+	name     string
+	messages []*regexp.Regexp
+	location *time.Location
+	markers  LogMarkers
+}
+
+func (p *jsonLogParser) Name() string {
+	return p.name
+}
+
+var (
+	jsonLogTimestampKeys = []string{"time", "timestamp", "Time", "Timestamp", "ts"}
+	jsonLogLevelKeys     = []string{"level", "Level", "lvl"}
+	jsonLogMessageKeys   = []string{"msg", "message", "Msg", "Message"}
+)
+
+func (p *jsonLogParser) Parse(line string) (LogEntry, bool, error) {
+	e := LogEntry{markers: &p.markers}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return e, false, nil
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return e, false, nil
+	}
+	msg := lookupString(record, jsonLogMessageKeys)
+	var re *regexp.Regexp
+	var matches []string
+	for _, re = range p.messages {
+		if matches = re.FindStringSubmatch(msg); matches != nil {
+			break
+		}
+	}
+	if matches == nil {
+		return e, false, nil
+	}
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "Component":
+			e.Component = matches[i]
+		case "DocumentID":
+			e.DocumentID = matches[i]
+		case "Message":
+			e.Message = matches[i]
+		}
+	}
+	e.LogLevel = strings.ToUpper(lookupString(record, jsonLogLevelKeys))
+	ts := lookupString(record, jsonLogTimestampKeys)
+	var err error
+	for _, layout := range []string{time.RFC3339Nano, defaultLogTimestampLayout, "2006-01-02 15:04:05.000"} {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, ts, p.location); err == nil {
+			e.Timestamp = t.UTC()
+			break
+		}
+	}
+	if err != nil {
+		return e, false, err
+	}
+	return e, true, nil
+}
+
+func lookupString(record map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if v, ok := record[key].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// LogParserReport is the result of CheckLogParsers for a single parser.
+type LogParserReport struct {
+	Name           string
+	MatchedLines   int
+	Sessions       int
+	ClosedSessions int
+	Errors         int
+	FirstError     error
+}
+
+// CheckLogParsers parses up to sampleLines lines with each parser, and returns reports ordered by best match first.
+func CheckLogParsers(r io.Reader, parsers []LogParser, sampleLines int) ([]LogParserReport, int, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() && (sampleLines <= 0 || len(lines) < sampleLines) {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	reports := make([]LogParserReport, 0, len(parsers))
+	for _, parser := range parsers {
+		report := LogParserReport{Name: parser.Name()}
+		sessions := map[string]bool{}
+		for _, line := range lines {
+			e, ok, err := parser.Parse(line)
+			if err != nil {
+				report.Errors++
+				if report.FirstError == nil {
+					report.FirstError = err
+				}
+				continue
+			}
+			if !ok || e.DocumentID == "" {
+				continue
+			}
+			report.MatchedLines++
+			if _, seen := sessions[e.DocumentID]; !seen && e.IsSessionOpened() {
+				sessions[e.DocumentID] = false
+			}
+			if closed, seen := sessions[e.DocumentID]; seen && !closed && e.IsSessionWorkerClosed() {
+				sessions[e.DocumentID] = true
+				report.ClosedSessions++
+			}
+		}
+		report.Sessions = len(sessions)
+		reports = append(reports, report)
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Errors != reports[j].Errors {
+			return reports[i].Errors < reports[j].Errors
+		}
+		return reports[i].MatchedLines > reports[j].MatchedLines
+	})
+	return reports, len(lines), nil
+}
+
+// RunCheckLogParser writes a report of which parser profile matches a sample of the SSM Agent Log.
+func RunCheckLogParser(w io.Writer, path string, cfg LogParserConfig, sampleLines int) error {
+	selected, err := NewLogParser(cfg)
+	if err != nil {
+		return err
+	}
+	parsers := []LogParser{DefaultLogParser}
+	for _, profile := range []string{LogParserAgentJSON, LogParserCustom} {
+		if profile == selected.Name() {
+			parsers = append(parsers, selected)
+			continue
+		}
+		if profile == LogParserCustom && cfg.Regex == "" {
+			continue
+		}
+		parser, err := NewLogParser(LogParserConfig{
+			Profile:         profile,
+			Regex:           cfg.Regex,
+			TimestampLayout: cfg.TimestampLayout,
+			OpenMarker:      cfg.OpenMarker,
+			CloseMarker:     cfg.CloseMarker,
+		})
+		if err != nil {
+			return err
+		}
+		parsers = append(parsers, parser)
+	}
+	reader, err := OpenLogFile(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	reports, lines, err := CheckLogParsers(reader, parsers, sampleLines)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "sampled %d lines of %s\n", lines, path)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tMATCHED\tSESSIONS\tCLOSED\tERRORS\t")
+	for _, report := range reports {
+		name := report.Name
+		if name == selected.Name() {
+			name += " (selected)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n", name, report.MatchedLines, report.Sessions, report.ClosedSessions, report.Errors)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if report.FirstError != nil {
+			fmt.Fprintf(w, "%s: first error: %s\n", report.Name, report.FirstError)
+		}
+	}
+	if len(reports) == 0 || reports[0].MatchedLines == 0 {
+		return errors.New("no log parser profile matches the SSM Agent Log")
+	}
+	fmt.Fprintf(w, "best match: %s\n", reports[0].Name)
+	for _, report := range reports {
+		if report.Name == selected.Name() && report.MatchedLines == 0 {
+			return fmt.Errorf("selected log parser profile %s does not match the SSM Agent Log", selected.Name())
+		}
+	}
+	return nil
+}
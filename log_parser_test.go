@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogParser__AgentJSON(t *testing.T) {
+	parser, err := NewLogParser(LogParserConfig{Profile: LogParserAgentJSON})
+	require.NoError(t, err)
+	e, ok, err := parser.Parse(`{"time":"2023-11-17T07:45:32Z","level":"info","msg":"[ssm-session-worker] [ecs-execute-command-02f7755870b50f125] Session worker closed"}`)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 45, 32, 0, time.UTC), e.Timestamp)
+	require.Equal(t, "ecs-execute-command-02f7755870b50f125", e.DocumentID)
+	require.True(t, e.IsSessionWorkerClosed())
+	require.Equal(t, CloseSignalSessionWorkerClosed, e.CloseSignal())
+
+	e, ok, err = parser.Parse(`{"time":"2023-11-17T07:45:32Z","level":"info","msg":"[ssm-agent-worker] [MessageService] [EngineProcessor] [BasicExecuter] [ecs-execute-command-02f7755870b50f125] Executer closed"}`)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "ecs-execute-command-02f7755870b50f125", e.DocumentID)
+	require.Equal(t, CloseSignalExecuterClosed, e.CloseSignal())
+
+	_, ok, err = parser.Parse(`{"time":"2023-11-17T07:45:32Z","level":"info","msg":"[ssm-agent-worker] [MessageService] starting MessageService"}`)
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = parser.Parse("2023-11-17 07:45:32 INFO [ssm-session-worker] [ecs-execute-command-02f7755870b50f125] Session worker closed")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestLogParser__Custom(t *testing.T) {
+	parser, err := NewLogParser(LogParserConfig{
+		Profile:         LogParserCustom,
+		Regex:           `^(?P<Timestamp>\S+) (?P<LogLevel>\S+) session=(?P<DocumentID>\S+) (?P<Message>.*)$`,
+		TimestampLayout: time.RFC3339,
+		OpenMarker:      "session opened",
+		CloseMarker:     "session terminated",
+	})
+	require.NoError(t, err)
+	log := strings.Join([]string{
+		"2023-11-17T07:09:48Z INFO session=sess-1 heartbeat before open",
+		"2023-11-17T07:09:49Z INFO session=sess-1 session opened",
+		"2023-11-17T07:10:20Z INFO session=sess-1 session terminated",
+		"2023-11-17T07:40:39Z INFO session=sess-2 session opened",
+		"2023-11-17T07:40:40Z INFO session=sess-3 heartbeat without open",
+	}, "\n") + "\n"
+	m := NewMonitorWithOptions("", MonitorOptions{Parser: parser})
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(log)))
+	metrics := m.Metrics()
+	require.Equal(t, 2, metrics.TotalConnections)
+	require.Equal(t, 1, metrics.ActiveConnections)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 40, 39, 0, time.UTC), metrics.LastTimestamp)
+}
+
+func TestLogParser__InvalidConfig(t *testing.T) {
+	_, err := NewLogParser(LogParserConfig{Profile: LogParserCustom})
+	require.Error(t, err)
+	_, err = NewLogParser(LogParserConfig{Profile: LogParserCustom, Regex: `^(?P<Timestamp>\S+) (?P<Message>.*)$`})
+	require.Error(t, err)
+	_, err = NewLogParser(LogParserConfig{Profile: "unknown"})
+	require.Error(t, err)
+}
+
+func TestRunCheckLogParser(t *testing.T) {
+	var buf bytes.Buffer
+	err := RunCheckLogParser(&buf, "testdata/amazon-ssm-agent.log", LogParserConfig{Profile: LogParserAgentV3Text}, 1000)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "best match: agent-v3-text")
+
+	buf.Reset()
+	err = RunCheckLogParser(&buf, "testdata/amazon-ssm-agent.log", LogParserConfig{Profile: LogParserAgentJSON}, 1000)
+	require.Error(t, err)
+	require.Contains(t, buf.String(), "best match: agent-v3-text")
+
+	bs, err := os.ReadFile("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	reports, lines, err := CheckLogParsers(bytes.NewReader(bs), []LogParser{DefaultLogParser}, 0)
+	require.NoError(t, err)
+	require.Equal(t, 350, lines)
+	require.Equal(t, 4, reports[0].Sessions)
+	require.Equal(t, 3, reports[0].ClosedSessions)
+}
+
+func TestLogParser__Timezone(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	parser, err := NewLogParser(LogParserConfig{Profile: LogParserAgentV3Text, Location: loc})
+	require.NoError(t, err)
+	e, ok, err := parser.Parse("2023-11-17 16:45:32 INFO [ssm-session-worker] [ecs-execute-command-02f7755870b50f125] Session worker closed")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 45, 32, 0, time.UTC), e.Timestamp)
+
+	parser, err = NewLogParser(LogParserConfig{Profile: LogParserAgentJSON, Location: loc})
+	require.NoError(t, err)
+	e, ok, err = parser.Parse(`{"time":"2023-11-17T07:45:32Z","level":"INFO","msg":"[ssm-session-worker] [ecs-execute-command-02f7755870b50f125] Session worker closed"}`)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 45, 32, 0, time.UTC), e.Timestamp, "explicit zone in the timestamp wins")
+}
+
+func TestLogParser__AgentJSONAgentHealth(t *testing.T) {
+	parser, err := NewLogParser(LogParserConfig{Profile: LogParserAgentJSON})
+	require.NoError(t, err)
+	e, ok, err := parser.Parse(`{"time":"2023-11-17T07:08:48Z","level":"info","msg":"[amazon-ssm-agent] Starting Core Agent"}`)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, e.IsAgentStarted())
+	e, ok, err = parser.Parse(`{"time":"2023-11-17T07:08:51Z","level":"info","msg":"[ssm-agent-worker] [MessageService] processor initialization completed for worker ssm-session-worker belonging to MGSInteractor"}`)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, e.IsAgentReady())
+}
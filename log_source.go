@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ExpandLogFiles returns files matching the glob pattern in chronological order (oldest first), excluding the given paths.
+func ExpandLogFiles(pattern string, excludes ...string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool, len(excludes))
+	for _, exclude := range excludes {
+		if abs, err := filepath.Abs(exclude); err == nil {
+			excluded[abs] = true
+		}
+	}
+	type logFile struct {
+		path    string
+		modTime int64
+	}
+	files := make([]logFile, 0, len(matches))
+	for _, match := range matches {
+		abs, err := filepath.Abs(match)
+		if err != nil {
+			return nil, err
+		}
+		if excluded[abs] {
+			continue
+		}
+		stats, err := os.Stat(match)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if stats.IsDir() {
+			continue
+		}
+		files = append(files, logFile{path: match, modTime: stats.ModTime().UnixNano()})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].modTime != files[j].modTime {
+			return files[i].modTime < files[j].modTime
+		}
+		return files[i].path > files[j].path
+	})
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	return paths, nil
+}
+
+type logFileReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *logFileReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i].Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// OpenLogFile opens an archived log file, decompressing it if it is gzipped.
+func OpenLogFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(file)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &logFileReader{Reader: gz, closers: []io.Closer{file, gz}}, nil
+	}
+	return &logFileReader{Reader: br, closers: []io.Closer{file}}, nil
+}
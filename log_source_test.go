@@ -0,0 +1,65 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeGzip(t *testing.T, path string, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+}
+
+func TestExpandLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "amazon-ssm-agent.log")
+	now := time.Now()
+	files := map[string]time.Time{
+		live: now,
+		filepath.Join(dir, "amazon-ssm-agent.log.1"):    now.Add(-1 * time.Hour),
+		filepath.Join(dir, "amazon-ssm-agent.log.2.gz"): now.Add(-2 * time.Hour),
+		filepath.Join(dir, "amazon-ssm-agent.log.3.gz"): now.Add(-3 * time.Hour),
+	}
+	for path, modTime := range files {
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	actual, err := ExpandLogFiles(filepath.Join(dir, "amazon-ssm-agent.log*"), live)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "amazon-ssm-agent.log.3.gz"),
+		filepath.Join(dir, "amazon-ssm-agent.log.2.gz"),
+		filepath.Join(dir, "amazon-ssm-agent.log.1"),
+	}, actual)
+}
+
+func TestOpenLogFile(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.log")
+	gzipped := filepath.Join(dir, "gzipped.log.gz")
+	require.NoError(t, os.WriteFile(plain, []byte("plain line\n"), 0644))
+	writeGzip(t, gzipped, "gzipped line\n")
+	for path, expected := range map[string]string{
+		plain:   "plain line\n",
+		gzipped: "gzipped line\n",
+	} {
+		reader, err := OpenLogFile(path)
+		require.NoError(t, err)
+		bs, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, expected, string(bs))
+	}
+}
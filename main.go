@@ -7,8 +7,13 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	_ "time/tzdata"
+
+	"github.com/alecthomas/kong"
 )
 
+const checkLogParserSampleLines = 1000
+
 func main() {
 	if err := _main(); err != nil {
 		if str := err.Error(); !strings.HasPrefix("exit status ", str) {
@@ -27,11 +32,35 @@ func _main() error {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		var cli AnalyzeCLI
+		if err := cli.Parse(os.Args[2:]); err != nil {
+			return err
+		}
+		return RunAnalyze(ctx, os.Stdout, cli)
+	}
 	var cli CLI
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		err := cli.Parse(os.Args[2:],
+			kong.Name("ecs-task-self-terminator simulate"),
+			kong.Description("Replay SSM Agent Log with the given flags and print when and why the task would have been stopped"),
+		)
+		if err != nil {
+			return err
+		}
+		return RunSimulate(ctx, os.Stdout, cli)
+	}
 	err := cli.Parse(os.Args[1:])
 	if err != nil {
 		return err
 	}
+	if cli.CheckLogParser {
+		cfg, err := cli.LogParserConfig()
+		if err != nil {
+			return err
+		}
+		return RunCheckLogParser(os.Stdout, cli.SSMAgentLogLocation, cfg, checkLogParserSampleLines)
+	}
 	app, err := New(cli)
 	if err != nil {
 		return err
@@ -3,80 +3,443 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/Songmu/flextime"
 )
 
+type MonitorOptions struct {
+	TailMode TailMode
+	Parser   LogParser
+	Logger   *slog.Logger
+	// ClockSkewThreshold warns when log timestamps are far from the current time, disabled if zero.
+	ClockSkewThreshold time.Duration
+	// HistoryGlob matches rotated (optionally gzipped) logs, which are read once before tailing the live log.
+	HistoryGlob string
+	// StateFile is the path to checkpoint the tail position and session state, disabled if empty.
+	StateFile string
+	// TaskARN is written to the state file to identify the task.
+	TaskARN string
+	StartAt time.Time
+	// LogFileWaitTimeout marks the agent as AgentStatusLogNotFound when the log file is not created in this duration, disabled if zero.
+	// The log file is still waited for, and the agent failure policy decides whether the task keeps running.
+	LogFileWaitTimeout time.Duration
+	// CloseSignals are the signals that close a session, DefaultCloseSignals if empty.
+	CloseSignals []CloseSignal
+	// SessionCheckInterval is the interval to check whether IPC channels and processes of active sessions still exist, disabled if zero.
+	SessionCheckInterval time.Duration
+	// CheckSessionProcess reaps sessions whose ssm-session-worker process in /proc is gone.
+	CheckSessionProcess bool
+	// SessionInactivityTimeout counts open sessions without transcript I/O for this duration as inactive, disabled if zero.
+	SessionInactivityTimeout time.Duration
+	// MaxSessionDuration kills ssm-session-worker of sessions open longer than this duration, disabled if zero.
+	MaxSessionDuration time.Duration
+	// CheckAgentProcess marks the agent as stopped when its process seen in /proc is gone.
+	CheckAgentProcess bool
+	// SessionKillInactivityTimeout kills ssm-session-worker of sessions without transcript I/O for this duration, disabled if zero.
+	SessionKillInactivityTimeout time.Duration
+}
+
+const stateCheckpointInterval = 5 * time.Second
+
+// channelReconcileGracePeriod is the time for the SSM agent to create the IPC channel after a session opens.
+const channelReconcileGracePeriod = 30 * time.Second
+
+// sessionKillGracePeriod is the time to wait for ssm-session-worker to exit after SIGTERM before SIGKILL.
+const sessionKillGracePeriod = 10 * time.Second
+
 type Monitor struct {
 	logFilePath           string
+	opts                  MonitorOptions
 	mu                    sync.RWMutex
 	lastTimestamps        map[string]time.Time
 	IsSessionWorkerClosed map[string]bool
+	sessionInfos          map[string]SessionInfo
+	closedBy              map[string]CloseSignal
+	ipcChannels           map[string]string
+	ipcChannelSeen        map[string]bool
+	lastIO                map[string]time.Time
+	startedAt             map[string]time.Time
+	startPublished        map[string]bool
+	killing               map[string]bool
+	agent                 AgentHealth
+	agentProcessSeen      bool
+	subMu                 sync.Mutex
+	subscribers           map[chan SessionEvent]struct{}
+	handlers              []SessionEventHandler
 	metrics               Metrics
+	startPosition         *TailPosition
+	position              *TailPosition
+	clockSkew             string
 }
 
 func NewMonitor(logFilePath string) *Monitor {
+	return NewMonitorWithOptions(logFilePath, MonitorOptions{})
+}
+
+func NewMonitorWithOptions(logFilePath string, opts MonitorOptions) *Monitor {
 	return &Monitor{
 		logFilePath:           logFilePath,
+		opts:                  opts,
 		lastTimestamps:        map[string]time.Time{},
 		IsSessionWorkerClosed: map[string]bool{},
+		sessionInfos:          map[string]SessionInfo{},
+		closedBy:              map[string]CloseSignal{},
+		ipcChannels:           map[string]string{},
+		ipcChannelSeen:        map[string]bool{},
+		lastIO:                map[string]time.Time{},
+		startedAt:             map[string]time.Time{},
+		startPublished:        map[string]bool{},
+		killing:               map[string]bool{},
+		subscribers:           map[chan SessionEvent]struct{}{},
 	}
 }
 
 func (m *Monitor) Run(ctx context.Context) error {
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
-	var err error
+	var deadline time.Time
+	if m.opts.LogFileWaitTimeout > 0 {
+		deadline = time.Now().Add(m.opts.LogFileWaitTimeout)
+	}
+	for {
+		_, err := os.Stat(m.logFilePath)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			deadline = time.Time{}
+			m.markAgentLogNotFound()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(1 * time.Second):
+		}
+	}
+	if m.opts.HistoryGlob != "" && m.startPosition == nil {
+		if err := m.readHistory(ctx); err != nil {
+			return err
+		}
+	}
+	reader, err := NewTailReaderWithOptions(ctx, m.logFilePath, TailReaderOptions{
+		Mode:          m.opts.TailMode,
+		StartPosition: m.startPosition,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if m.opts.SessionCheckInterval > 0 {
+		go m.watchSessions(ctx)
+	}
+	if m.opts.StateFile != "" {
+		go m.checkpointLoop(ctx)
+	}
+	return m.RunWithReader(ctx, reader)
+}
+
+// checkpointLoop saves the state periodically, so that sessions closed without log lines,
+// such as by the IPC channel, process and kill checks, and the last I/O are persisted too.
+func (m *Monitor) checkpointLoop(ctx context.Context) {
+	ticker := time.NewTicker(stateCheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Checkpoint(); err != nil {
+			m.logger().WarnContext(ctx, "failed to save state file", "error", err)
+		}
+	}
+}
+
+func (m *Monitor) watchSessions(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.SessionCheckInterval)
+	defer ticker.Stop()
+	checkProcess := m.opts.CheckSessionProcess && m.closeSignalEnabled(CloseSignalProcessExited)
+	checkAgentProcess := m.opts.CheckAgentProcess
 	for {
 		select {
-		case <-timeoutCtx.Done():
-			if err != nil {
-				return err
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if m.closeSignalEnabled(CloseSignalIPCChannelRemoved) {
+			m.CheckIPCChannels()
+		}
+		m.CheckTranscripts()
+		m.EnforceSessionLimits(ctx)
+		if checkAgentProcess {
+			if err := m.CheckAgentProcess(); err != nil {
+				m.logger().WarnContext(ctx, "failed to check ssm agent process, disabled", "error", err)
+				checkAgentProcess = false
 			}
-			return timeoutCtx.Err()
-		default:
+		}
+		if checkProcess {
+			if err := m.CheckSessionProcesses(); err != nil {
+				m.logger().WarnContext(ctx, "failed to check session worker processes, disabled", "error", err)
+				checkProcess = false
+			}
+		}
+	}
+}
+
+// CheckIPCChannels closes active sessions whose IPC channel was seen and has been removed since,
+// which happens when ssm-session-worker dies without logging that it closed.
+func (m *Monitor) CheckIPCChannels() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for documentID, path := range m.ipcChannels {
+		if m.IsSessionWorkerClosed[documentID] {
+			continue
+		}
+		_, err := os.Stat(path)
+		switch {
+		case err == nil:
+			m.ipcChannelSeen[documentID] = true
+		case os.IsNotExist(err) && m.ipcChannelSeen[documentID]:
+			m.lastTimestamps[documentID] = flextime.Now()
+			m.closeLocked(documentID, CloseSignalIPCChannelRemoved)
+			changed = true
+		}
+	}
+	if changed {
+		m.updateMetricsLocked()
+	}
+}
+
+// ReconcileChannels closes active sessions whose IPC channel is not among the running channels,
+// for sessions whose "inter process communication started" line was not read, such as sessions resumed from rotated logs.
+func (m *Monitor) ReconcileChannels(running map[string]bool) {
+	if !m.closeSignalEnabled(CloseSignalIPCChannelRemoved) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := flextime.Now()
+	changed := false
+	for documentID, t := range m.lastTimestamps {
+		if running[documentID] || m.IsSessionWorkerClosed[documentID] {
+			continue
+		}
+		if startedAt, ok := m.startedAt[documentID]; ok {
+			t = startedAt
+		}
+		if now.Sub(t) <= channelReconcileGracePeriod {
+			continue
+		}
+		m.lastTimestamps[documentID] = now
+		m.closeLocked(documentID, CloseSignalIPCChannelRemoved)
+		changed = true
+	}
+	if changed {
+		m.updateMetricsLocked()
+	}
+}
 
+// CheckTranscripts records the last I/O of active sessions from the modification time of their transcripts.
+// It also refreshes inactive connections in Metrics, which change as time passes.
+func (m *Monitor) CheckTranscripts() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for documentID, info := range m.sessionInfos {
+		if info.TranscriptPath == "" || m.IsSessionWorkerClosed[documentID] {
+			continue
 		}
-		_, err = os.Stat(m.logFilePath)
+		stats, err := os.Stat(info.TranscriptPath)
 		if err != nil {
-			if os.IsNotExist(err) {
-				time.Sleep(1 * time.Second)
-				continue
+			continue
+		}
+		if modTime := stats.ModTime().UTC(); modTime.After(m.lastIO[documentID]) {
+			m.lastIO[documentID] = modTime
+			m.publishSessionEventLocked(SessionEventActivity, documentID)
+		}
+	}
+	m.updateMetricsLocked()
+}
+
+// EnforceSessionLimits kills ssm-session-worker of sessions over MaxSessionDuration or SessionKillInactivityTimeout,
+// and closes the sessions once the workers exit.
+func (m *Monitor) EnforceSessionLimits(ctx context.Context) {
+	if m.opts.MaxSessionDuration <= 0 && m.opts.SessionKillInactivityTimeout <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := flextime.Now()
+	for documentID, info := range m.sessionInfos {
+		if info.Pid == 0 || m.IsSessionWorkerClosed[documentID] || m.killing[documentID] {
+			continue
+		}
+		var reason string
+		if startedAt, ok := m.startedAt[documentID]; ok && m.opts.MaxSessionDuration > 0 && now.Sub(startedAt) > m.opts.MaxSessionDuration {
+			reason = "max session duration exceeded"
+		}
+		if lastIO, ok := m.lastIO[documentID]; ok && m.opts.SessionKillInactivityTimeout > 0 && now.Sub(lastIO) > m.opts.SessionKillInactivityTimeout {
+			reason = "session inactivity timeout exceeded"
+		}
+		if reason == "" {
+			continue
+		}
+		m.killing[documentID] = true
+		go m.killSession(ctx, info, reason)
+	}
+}
+
+func (m *Monitor) killSession(ctx context.Context, info SessionInfo, reason string) {
+	// killing is kept on failure, so a session that can not be killed is not retried on every check.
+	logger := m.logger().With("document_id", info.DocumentID, "pid", info.Pid, "reason", reason)
+	alive, err := isProcessAlive(info.Pid, info.ProcessStartTime)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to check session worker process, not killed", "error", err)
+		return
+	}
+	if alive {
+		logger.InfoContext(ctx, "killing session", "session_owner", info.SessionOwner)
+		if err := signalProcess(info.Pid, syscall.SIGTERM); err != nil {
+			logger.WarnContext(ctx, "failed to send SIGTERM to session worker", "error", err)
+			return
+		}
+		alive, err = m.waitProcessExit(ctx, info)
+		if err != nil {
+			logger.WarnContext(ctx, "stopped waiting for session worker to exit after SIGTERM, not sending SIGKILL", "error", err)
+			return
+		}
+		if alive {
+			logger.WarnContext(ctx, "session worker did not exit after SIGTERM, sending SIGKILL", "grace_period", sessionKillGracePeriod)
+			if err := signalProcess(info.Pid, syscall.SIGKILL); err != nil {
+				logger.WarnContext(ctx, "failed to send SIGKILL to session worker", "error", err)
+				return
 			}
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastTimestamps[info.DocumentID] = flextime.Now()
+	m.closeLocked(info.DocumentID, CloseSignalKilled)
+	m.updateMetricsLocked()
+	logger.InfoContext(ctx, "killed session")
+}
+
+// waitProcessExit reports whether the process is still alive after the grace period,
+// or returns the error of ctx if it is done before the grace period.
+func (m *Monitor) waitProcessExit(ctx context.Context, info SessionInfo) (bool, error) {
+	deadline := time.Now().Add(sessionKillGracePeriod)
+	for time.Now().Before(deadline) {
+		if alive, err := isProcessAlive(info.Pid, info.ProcessStartTime); err == nil && !alive {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return true, nil
+}
+
+func signalProcess(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
+
+// CheckSessionProcesses closes active sessions whose ssm-session-worker process has exited.
+func (m *Monitor) CheckSessionProcesses() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	defer func() {
+		if changed {
+			m.updateMetricsLocked()
+		}
+	}()
+	for documentID, info := range m.sessionInfos {
+		if info.Pid == 0 || m.IsSessionWorkerClosed[documentID] {
+			continue
+		}
+		alive, err := isProcessAlive(info.Pid, info.ProcessStartTime)
+		if err != nil {
 			return err
 		}
-		break
+		if !alive {
+			m.lastTimestamps[documentID] = flextime.Now()
+			m.closeLocked(documentID, CloseSignalProcessExited)
+			changed = true
+		}
 	}
-	reader, err := NewTailReaderWithContext(ctx, m.logFilePath)
+	return nil
+}
+
+func (m *Monitor) readHistory(ctx context.Context) error {
+	paths, err := ExpandLogFiles(m.opts.HistoryGlob, m.logFilePath)
 	if err != nil {
 		return err
 	}
-	return m.RunWithReader(ctx, reader)
+	for _, path := range paths {
+		reader, err := OpenLogFile(path)
+		if err != nil {
+			return err
+		}
+		err = m.RunWithReader(ctx, reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return nil
 }
 
 func (m *Monitor) RunWithReader(ctx context.Context, reader io.Reader) error {
+	var consumed int64
 	scanner := bufio.NewScanner(reader)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		consumed += int64(advance)
+		return advance, token, err
+	})
+	tailReader, isTailReader := reader.(*TailReader)
+	trackPosition := isTailReader && m.opts.StateFile != ""
+	if trackPosition {
+		m.setPosition(tailReader.Position(consumed))
+		defer m.Checkpoint()
+	}
 	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-		}
 		line := scanner.Text()
-		var e LogEntry
-		ok, err := e.Parse(line)
+		e, ok, err := m.parser().Parse(line)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			continue
+		if ok {
+			m.checkClockSkew(ctx, e, isTailReader && tailReader.CaughtUp())
+			m.mark(e)
+		}
+		if trackPosition {
+			m.setPosition(tailReader.Position(consumed))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
 		}
-		m.mark(e)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -88,35 +451,282 @@ func (m *Monitor) RunWithReader(ctx context.Context, reader io.Reader) error {
 	return nil
 }
 
+// Restore resumes the session state and tail position from a checkpoint.
+func (m *Monitor) Restore(state *State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for documentID, t := range state.LastTimestamps {
+		m.lastTimestamps[documentID] = t
+		m.startPublished[documentID] = true
+	}
+	for documentID, closed := range state.IsSessionWorkerClosed {
+		m.IsSessionWorkerClosed[documentID] = closed
+	}
+	for documentID, info := range state.SessionInfos {
+		m.sessionInfos[documentID] = info
+	}
+	for documentID, signal := range state.ClosedBy {
+		m.closedBy[documentID] = signal
+	}
+	for documentID, path := range state.IPCChannels {
+		m.ipcChannels[documentID] = path
+	}
+	for documentID, t := range state.StartedAt {
+		m.startedAt[documentID] = t
+	}
+	for documentID, t := range state.LastIO {
+		m.lastIO[documentID] = t
+	}
+	if state.Agent != nil {
+		m.agent = *state.Agent
+	}
+	position := state.Position
+	m.startPosition = &position
+	m.updateMetricsLocked()
+}
+
+func (m *Monitor) setPosition(position TailPosition) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.position = &position
+}
+
+// Checkpoint saves the state at the position read in the live log, nothing is saved before the live log is opened.
+func (m *Monitor) Checkpoint() error {
+	m.mu.RLock()
+	position := m.position
+	m.mu.RUnlock()
+	if position == nil {
+		return nil
+	}
+	return m.SaveState(*position)
+}
+
+func (m *Monitor) SaveState(position TailPosition) error {
+	m.mu.RLock()
+	agent := m.agent
+	state := &State{
+		TaskARN:               m.opts.TaskARN,
+		StartAt:               m.opts.StartAt,
+		UpdatedAt:             flextime.Now(),
+		Position:              position,
+		LastTimestamps:        make(map[string]time.Time, len(m.lastTimestamps)),
+		IsSessionWorkerClosed: make(map[string]bool, len(m.IsSessionWorkerClosed)),
+		SessionInfos:          make(map[string]SessionInfo, len(m.sessionInfos)),
+		ClosedBy:              make(map[string]CloseSignal, len(m.closedBy)),
+		IPCChannels:           make(map[string]string, len(m.ipcChannels)),
+		StartedAt:             make(map[string]time.Time, len(m.startedAt)),
+		LastIO:                make(map[string]time.Time, len(m.lastIO)),
+		Agent:                 &agent,
+	}
+	for documentID, t := range m.lastTimestamps {
+		state.LastTimestamps[documentID] = t
+	}
+	for documentID, closed := range m.IsSessionWorkerClosed {
+		state.IsSessionWorkerClosed[documentID] = closed
+	}
+	for documentID, info := range m.sessionInfos {
+		state.SessionInfos[documentID] = info
+	}
+	for documentID, signal := range m.closedBy {
+		state.ClosedBy[documentID] = signal
+	}
+	for documentID, path := range m.ipcChannels {
+		state.IPCChannels[documentID] = path
+	}
+	for documentID, t := range m.startedAt {
+		state.StartedAt[documentID] = t
+	}
+	for documentID, t := range m.lastIO {
+		state.LastIO[documentID] = t
+	}
+	m.mu.RUnlock()
+	return state.Save(m.opts.StateFile)
+}
+
+func (m *Monitor) logger() *slog.Logger {
+	if m.opts.Logger == nil {
+		return slog.Default()
+	}
+	return m.opts.Logger
+}
+
+// checkClockSkew warns once when log timestamps start to be in the future, or in the past while tailing new lines.
+// Old lines read before catching up with the live log are naturally in the past.
+func (m *Monitor) checkClockSkew(ctx context.Context, e LogEntry, live bool) {
+	if m.opts.ClockSkewThreshold <= 0 {
+		return
+	}
+	skew := e.Timestamp.Sub(flextime.Now())
+	var direction string
+	switch {
+	case skew > m.opts.ClockSkewThreshold:
+		direction = "future"
+	case live && -skew > m.opts.ClockSkewThreshold:
+		direction = "past"
+	}
+	if direction == m.clockSkew {
+		return
+	}
+	m.clockSkew = direction
+	if direction == "" {
+		m.logger().InfoContext(ctx, "ssm agent log timestamps are back within the clock skew threshold")
+		return
+	}
+	m.logger().WarnContext(ctx, "ssm agent log timestamp is far in the "+direction+", check the timezone of the ssm agent log",
+		"log_timestamp", e.Timestamp,
+		"skew", skew,
+		"threshold", m.opts.ClockSkewThreshold,
+	)
+}
+
+func (m *Monitor) parser() LogParser {
+	if m.opts.Parser == nil {
+		return DefaultLogParser
+	}
+	return m.opts.Parser
+}
+
 func (m *Monitor) mark(e LogEntry) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if e.DocumentID == "" {
+		m.markAgentLocked(e)
+		return
+	}
+	m.inferAgentReadyLocked(e)
+	if _, ok := m.lastTimestamps[e.DocumentID]; !ok {
+		if !e.IsSessionOpened() {
+			return
+		}
+		m.startedAt[e.DocumentID] = e.Timestamp
+	}
 	m.lastTimestamps[e.DocumentID] = e.Timestamp
-	if e.IsSessionWorkerClosed() {
-		m.IsSessionWorkerClosed[e.DocumentID] = true
+	if signal := e.CloseSignal(); signal != "" && m.closeSignalEnabled(signal) {
+		m.closeLocked(e.DocumentID, signal)
+	} else if e.IsFromSessionWorker() && m.closedBy[e.DocumentID].IsWeak() {
+		m.logger().Debug("session reopened", "document_id", e.DocumentID, "closed_by", m.closedBy[e.DocumentID])
+		delete(m.closedBy, e.DocumentID)
+		m.IsSessionWorkerClosed[e.DocumentID] = false
+	}
+	if path, ok := e.IPCChannel(); ok {
+		m.ipcChannels[e.DocumentID] = path
+	}
+	if config, ok := e.PluginConfig(); ok {
+		info := config.SessionInfo()
+		info.DocumentID = e.DocumentID
+		m.sessionInfos[e.DocumentID] = info
+		m.publishStartedLocked(e.DocumentID)
+	}
+	m.updateMetricsLocked()
+}
+
+// closeLocked closes the session, a weak signal does not override the signal that already closed it.
+func (m *Monitor) closeLocked(documentID string, signal CloseSignal) {
+	wasClosed := m.IsSessionWorkerClosed[documentID]
+	if wasClosed && !(m.closedBy[documentID].IsWeak() && !signal.IsWeak()) {
+		return
+	}
+	m.IsSessionWorkerClosed[documentID] = true
+	m.closedBy[documentID] = signal
+	m.logger().Debug("session closed", "document_id", documentID, "closed_by", signal)
+	// a weak signal may be followed by lines that reopen the session.
+	if signal.IsWeak() {
+		return
 	}
-	var activeConnections, TotalConnections int
+	m.publishStartedLocked(documentID)
+	m.publishSessionEventLocked(SessionEventClosed, documentID)
+}
+
+// publishStartedLocked publishes SessionStarted once per session, when the plugin config is read,
+// or before SessionClosed of a session whose plugin config was not read.
+func (m *Monitor) publishStartedLocked(documentID string) {
+	if m.startPublished[documentID] {
+		return
+	}
+	m.startPublished[documentID] = true
+	m.publishSessionEventLocked(SessionEventStarted, documentID)
+}
+
+func (m *Monitor) closeSignalEnabled(signal CloseSignal) bool {
+	if len(m.opts.CloseSignals) == 0 {
+		return slices.Contains(DefaultCloseSignals, signal)
+	}
+	return slices.Contains(m.opts.CloseSignals, signal)
+}
+
+func (m *Monitor) updateMetricsLocked() {
+	var activeConnections, inactiveConnections, TotalConnections int
 	var lastTimestamp time.Time
+	byType := map[SessionType]SessionTypeMetrics{}
+	now := flextime.Now()
 	for documentID, t := range m.lastTimestamps {
+		if lastIO := m.lastIO[documentID]; lastIO.After(t) {
+			t = lastIO
+		}
+		sessionType := m.sessionTypeLocked(documentID)
+		typeMetrics := byType[sessionType]
 		if t.After(lastTimestamp) {
 			lastTimestamp = t
 		}
+		if t.After(typeMetrics.LastTimestamp) {
+			typeMetrics.LastTimestamp = t
+		}
 		TotalConnections++
+		typeMetrics.TotalConnections++
 		if !m.IsSessionWorkerClosed[documentID] {
 			activeConnections++
+			typeMetrics.ActiveConnections++
+			if m.isInactiveLocked(documentID, now) {
+				inactiveConnections++
+				typeMetrics.InactiveConnections++
+			}
 		}
+		byType[sessionType] = typeMetrics
 	}
 	m.metrics = Metrics{
-		ActiveConnections: activeConnections,
-		TotalConnections:  TotalConnections,
-		LastTimestamp:     lastTimestamp,
+		ActiveConnections:   activeConnections,
+		InactiveConnections: inactiveConnections,
+		TotalConnections:    TotalConnections,
+		LastTimestamp:       lastTimestamp,
+		ByType:              byType,
+	}
+}
+
+func (m *Monitor) isInactiveLocked(documentID string, now time.Time) bool {
+	lastIO, ok := m.lastIO[documentID]
+	return ok && m.opts.SessionInactivityTimeout > 0 && now.Sub(lastIO) > m.opts.SessionInactivityTimeout
+}
+
+func (m *Monitor) sessionTypeLocked(documentID string) SessionType {
+	if info, ok := m.sessionInfos[documentID]; ok && info.Type != "" {
+		return info.Type
 	}
+	return SessionTypeUnknown
 }
 
 type Metrics struct {
 	ActiveConnections int
-	TotalConnections  int
-	LastTimestamp     time.Time
+	// InactiveConnections are active connections without I/O for the session inactivity timeout.
+	InactiveConnections int
+	TotalConnections    int
+	LastTimestamp       time.Time
+	ByType              map[SessionType]SessionTypeMetrics
+}
+
+type SessionTypeMetrics struct {
+	ActiveConnections   int
+	InactiveConnections int
+	TotalConnections    int
+	LastTimestamp       time.Time
+}
+
+func (m *Monitor) Name() string {
+	return SessionSourceLog
+}
+
+func (m *Monitor) LastActivity() time.Time {
+	return lastActivityOf(m.Metrics())
 }
 
 func (m *Monitor) Metrics() Metrics {
@@ -125,39 +735,131 @@ func (m *Monitor) Metrics() Metrics {
 	return m.metrics
 }
 
+func (m *Monitor) SessionInfo(documentID string) (SessionInfo, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info, ok := m.sessionInfos[documentID]
+	return info, ok
+}
+
+// LastIO returns the last time the session transcript was written.
+func (m *Monitor) LastIO(documentID string) (time.Time, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.lastIO[documentID]
+	return t, ok
+}
+
+// ClosedBy returns the signal that closed the session.
+func (m *Monitor) ClosedBy(documentID string) (CloseSignal, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	signal, ok := m.closedBy[documentID]
+	return signal, ok
+}
+
 type LogEntry struct {
 	Timestamp  time.Time
 	LogLevel   string
+	Component  string
 	DocumentID string
 	Message    string
+	markers    *LogMarkers
 }
 
-var logEntryRegex = regexp.MustCompile(`^(?P<Timestamp>\S+ \S+) (?P<LogLevel>\S+) \[ssm-session-worker\] \[(?P<DocumentID>\S+)\] (?P<Extra>\[.*\] )?(?P<Message>.*)$`)
+const (
+	componentSessionWorker = "ssm-session-worker"
+	componentAgentWorker   = "ssm-agent-worker"
+	componentCoreAgent     = "amazon-ssm-agent"
+)
+
+var logEntryRegex = regexp.MustCompile(`^(?P<Timestamp>\S+ \S+) (?P<LogLevel>\S+) \[(?P<Component>ssm-session-worker)\] \[(?P<DocumentID>\S+)\] (?P<Extra>\[.*\] )?(?P<Message>.*)$`)
+
+// agentWorkerLogEntryRegex matches lines that ssm-agent-worker writes about a session, such as "requested terminate messaging worker".
+var agentWorkerLogEntryRegex = regexp.MustCompile(`^(?P<Timestamp>\S+ \S+) (?P<LogLevel>\S+) \[(?P<Component>ssm-agent-worker)\] (?:\[\w+\] )*\[BasicExecuter\] \[(?P<DocumentID>\S+)\] (?P<Message>.*)$`)
+
+// agentLogEntryRegex matches lines of the agent without a session, such as "Starting Core Agent".
+var agentLogEntryRegex = regexp.MustCompile(`^(?P<Timestamp>\S+ \S+) (?P<LogLevel>\S+) \[(?P<Component>amazon-ssm-agent|ssm-agent-worker)\] (?P<Message>.*)$`)
+
+var logEntryRegexes = []*regexp.Regexp{logEntryRegex, agentWorkerLogEntryRegex, agentLogEntryRegex}
 
 func (e *LogEntry) Parse(line string) (bool, error) {
-	matches := logEntryRegex.FindStringSubmatch(line)
-	if matches == nil {
-		return false, nil
-	}
-	for i, name := range logEntryRegex.SubexpNames() {
-		switch name {
-		case "Timestamp":
-			t, err := time.Parse("2006-01-02 15:04:05", matches[i])
-			if err != nil {
-				return false, err
-			}
-			e.Timestamp = t
-		case "LogLevel":
-			e.LogLevel = matches[i]
-		case "DocumentID":
-			e.DocumentID = matches[i]
-		case "Message":
-			e.Message = matches[i]
-		}
+	parsed, ok, err := DefaultLogParser.Parse(line)
+	if err != nil || !ok {
+		return false, err
 	}
+	*e = parsed
 	return true, nil
 }
 
+func (e LogEntry) logMarkers() LogMarkers {
+	if e.markers == nil {
+		return defaultLogMarkers
+	}
+	return *e.markers
+}
+
 func (e LogEntry) IsSessionWorkerClosed() bool {
-	return strings.EqualFold(e.LogLevel, "INFO") && strings.Contains(strings.ToLower(e.Message), "session worker closed")
+	marker := strings.ToLower(e.logMarkers().Close)
+	return strings.EqualFold(e.LogLevel, "INFO") && strings.Contains(strings.ToLower(e.Message), marker)
+}
+
+func (e LogEntry) IsAgentStarted() bool {
+	return e.Component == componentCoreAgent && strings.Contains(strings.ToLower(e.Message), "starting core agent")
+}
+
+func (e LogEntry) IsAgentReady() bool {
+	return e.Component == componentAgentWorker && strings.Contains(strings.ToLower(e.Message), "processor initialization completed for worker ssm-session-worker")
+}
+
+func (e LogEntry) IsAgentStopping() bool {
+	return e.Component == componentCoreAgent && strings.Contains(strings.ToLower(e.Message), "stopping core agent")
+}
+
+// IsFromSessionWorker reports whether ssm-session-worker wrote the line, custom parsers without Component are treated as such.
+func (e LogEntry) IsFromSessionWorker() bool {
+	return e.Component == "" || e.Component == componentSessionWorker
+}
+
+func (e LogEntry) CloseSignal() CloseSignal {
+	message := strings.ToLower(e.Message)
+	switch {
+	case e.Component == componentAgentWorker:
+		switch {
+		case strings.Contains(message, "requested terminate messaging worker"):
+			return CloseSignalTerminateRequested
+		case strings.Contains(message, "executer closed"):
+			return CloseSignalExecuterClosed
+		}
+	case e.IsSessionWorkerClosed():
+		return CloseSignalSessionWorkerClosed
+	case strings.EqualFold(e.LogLevel, "ERROR"):
+		return CloseSignalError
+	}
+	return ""
+}
+
+// IPCChannel returns the path of the IPC channel between ssm-agent-worker and ssm-session-worker.
+func (e LogEntry) IPCChannel() (string, bool) {
+	const prefix = "inter process communication started at "
+	if !e.IsFromSessionWorker() || !strings.HasPrefix(e.Message, prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(e.Message, prefix)), true
+}
+
+func (e LogEntry) IsSessionOpened() bool {
+	marker := strings.ToLower(e.logMarkers().Open)
+	return marker == "" || strings.Contains(strings.ToLower(e.Message), marker)
+}
+
+func (e LogEntry) PluginConfig() (PluginConfig, bool) {
+	var config PluginConfig
+	if !strings.HasPrefix(e.Message, `{"DocumentInformation"`) {
+		return config, false
+	}
+	if err := json.Unmarshal([]byte(e.Message), &config); err != nil {
+		return config, false
+	}
+	return config, true
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/Songmu/flextime"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,6 +25,18 @@ func TestMonitor__HasActiveConnections(t *testing.T) {
 		ActiveConnections: 1,
 		TotalConnections:  4,
 		LastTimestamp:     time.Date(2023, 11, 17, 7, 40, 40, 0, time.UTC),
+		ByType: map[SessionType]SessionTypeMetrics{
+			SessionTypeExec: {
+				ActiveConnections: 1,
+				TotalConnections:  3,
+				LastTimestamp:     time.Date(2023, 11, 17, 7, 40, 40, 0, time.UTC),
+			},
+			SessionTypePortForward: {
+				ActiveConnections: 0,
+				TotalConnections:  1,
+				LastTimestamp:     time.Date(2023, 11, 17, 7, 40, 35, 0, time.UTC),
+			},
+		},
 	}, m.Metrics())
 }
 
@@ -41,5 +57,282 @@ func TestMonitor__NoActiveConnections(t *testing.T) {
 		ActiveConnections: 0,
 		TotalConnections:  4,
 		LastTimestamp:     time.Date(2023, 11, 17, 7, 45, 32, 0, time.UTC),
+		ByType: map[SessionType]SessionTypeMetrics{
+			SessionTypeExec: {
+				ActiveConnections: 0,
+				TotalConnections:  3,
+				LastTimestamp:     time.Date(2023, 11, 17, 7, 45, 32, 0, time.UTC),
+			},
+			SessionTypePortForward: {
+				ActiveConnections: 0,
+				TotalConnections:  1,
+				LastTimestamp:     time.Date(2023, 11, 17, 7, 40, 35, 0, time.UTC),
+			},
+		},
 	}, m.Metrics())
 }
+
+func TestMonitor__SessionInfo(t *testing.T) {
+	file, err := os.Open("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	defer file.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	m := NewMonitor("")
+	err = m.RunWithReader(ctx, file)
+	require.NoError(t, err)
+	info, ok := m.SessionInfo("ecs-execute-command-02f7755870b50f125")
+	require.True(t, ok)
+	require.EqualValues(t, SessionInfo{
+		DocumentID:       "ecs-execute-command-02f7755870b50f125",
+		DocumentName:     "AmazonECS-ExecuteInteractiveCommand",
+		Type:             SessionTypeExec,
+		SessionOwner:     "arn:aws:sts::123456789012:assumed-role/AWSServiceRoleForECS/ecs-execute-command",
+		Command:          "sh",
+		Pid:              71,
+		ProcessStartTime: time.Date(2023, 11, 17, 7, 40, 39, 707783207, time.UTC),
+		TranscriptPath:   "/var/lib/amazon/ssm/00000000000000000000000000000000-000000000/session/orchestration/ecs-execute-command-02f7755870b50f125/InteractiveCommands/ecs-execute-command-02f7755870b50f125.log",
+	}, info)
+	info, ok = m.SessionInfo("aws-go-sdk-1700206823550536000-0749df7ec4fc89a00")
+	require.True(t, ok)
+	require.Equal(t, SessionTypePortForward, info.Type)
+	require.Equal(t, "AWS-StartPortForwardingSession", info.DocumentName)
+	require.Equal(t, "80", info.PortNumber)
+	require.Equal(t, "80", info.LocalPortNumber)
+}
+
+func TestMonitor__ResumeFromState(t *testing.T) {
+	bs, err := os.ReadFile("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(bs), "\n")
+	firstHalf := strings.Join(lines[:len(lines)/2], "")
+	secondHalf := strings.Join(lines[len(lines)/2:], "")
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "amazon-ssm-agent.log")
+	stateFile := filepath.Join(dir, "state.json")
+	require.NoError(t, os.WriteFile(logFile, []byte(firstHalf), 0644))
+	startAt := time.Date(2023, 11, 17, 7, 8, 48, 0, time.UTC)
+
+	m := NewMonitorWithOptions(logFile, MonitorOptions{StateFile: stateFile, StartAt: startAt})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, m.Run(ctx))
+
+	state, err := LoadState(stateFile)
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	require.Equal(t, startAt, state.StartAt)
+	require.EqualValues(t, len(firstHalf), state.Position.Offset)
+	require.Equal(t, m.Metrics().TotalConnections, len(state.LastTimestamps))
+
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(secondHalf)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	resumed := NewMonitorWithOptions(logFile, MonitorOptions{StateFile: stateFile, StartAt: state.StartAt})
+	resumed.Restore(state)
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, resumed.Run(ctx))
+
+	expected := NewMonitor("")
+	require.NoError(t, expected.RunWithReader(context.Background(), strings.NewReader(string(bs))))
+	require.EqualValues(t, expected.Metrics(), resumed.Metrics())
+
+	state, err = LoadState(stateFile)
+	require.NoError(t, err)
+	require.EqualValues(t, len(bs), state.Position.Offset)
+}
+
+func TestMonitor__CheckpointWithoutLogLines(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 7, 45, 0, 0, time.UTC))
+	defer restore()
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "amazon-ssm-agent.log")
+	stateFile := filepath.Join(dir, "state.json")
+	bs, err := os.ReadFile("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(logFile, bs, 0644))
+
+	m := NewMonitorWithOptions(logFile, MonitorOptions{StateFile: stateFile, TaskARN: "task-1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, m.Run(ctx))
+
+	documentID := "ecs-execute-command-02f7755870b50f125"
+	lastIO := time.Date(2023, 11, 17, 7, 44, 0, 0, time.UTC)
+	m.mu.Lock()
+	m.lastIO[documentID] = lastIO
+	m.closeLocked(documentID, CloseSignalProcessExited)
+	m.mu.Unlock()
+	require.NoError(t, m.Checkpoint())
+
+	state, err := LoadState(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, "task-1", state.TaskARN)
+	require.Equal(t, CloseSignalProcessExited, state.ClosedBy[documentID])
+	resumed := NewMonitor(logFile)
+	resumed.Restore(state)
+	require.Zero(t, resumed.Metrics().ActiveConnections)
+	restored, ok := resumed.LastIO(documentID)
+	require.True(t, ok)
+	require.Equal(t, lastIO, restored)
+}
+
+func TestMonitor__ReadHistory(t *testing.T) {
+	bs, err := os.ReadFile("testdata/amazon-ssm-agent.log")
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(bs), "\n")
+	third := len(lines) / 3
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "amazon-ssm-agent.log")
+	oldest := filepath.Join(dir, "amazon-ssm-agent.log.2.gz")
+	older := filepath.Join(dir, "amazon-ssm-agent.log.1")
+	writeGzip(t, oldest, strings.Join(lines[:third], ""))
+	require.NoError(t, os.WriteFile(older, []byte(strings.Join(lines[third:2*third], "")), 0644))
+	require.NoError(t, os.WriteFile(logFile, []byte(strings.Join(lines[2*third:], "")), 0644))
+	now := time.Now()
+	require.NoError(t, os.Chtimes(oldest, now.Add(-2*time.Hour), now.Add(-2*time.Hour)))
+	require.NoError(t, os.Chtimes(older, now.Add(-1*time.Hour), now.Add(-1*time.Hour)))
+
+	m := NewMonitorWithOptions(logFile, MonitorOptions{HistoryGlob: filepath.Join(dir, "amazon-ssm-agent.log*")})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, m.Run(ctx))
+
+	expected := NewMonitor("")
+	require.NoError(t, expected.RunWithReader(context.Background(), strings.NewReader(string(bs))))
+	require.EqualValues(t, expected.Metrics(), m.Metrics())
+	require.Equal(t, 4, m.Metrics().TotalConnections)
+}
+
+func TestMonitor__ClockSkew(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 7, 45, 0, 0, time.UTC))
+	defer restore()
+	var buf bytes.Buffer
+	m := NewMonitorWithOptions("", MonitorOptions{
+		Logger:             slog.New(slog.NewTextHandler(&buf, nil)),
+		ClockSkewThreshold: 5 * time.Minute,
+	})
+	log := strings.Join([]string{
+		"2023-11-17 07:44:59 INFO [ssm-session-worker] [ecs-execute-command-02f7755870b50f125] worker started",
+		"2023-11-17 16:45:00 INFO [ssm-session-worker] [ecs-execute-command-02f7755870b50f125] in the future",
+		"2023-11-17 16:45:01 INFO [ssm-session-worker] [ecs-execute-command-02f7755870b50f125] still in the future",
+	}, "\n") + "\n"
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(log)))
+	require.Equal(t, 1, strings.Count(buf.String(), "far in the future"), buf.String())
+	require.NotContains(t, buf.String(), "far in the past", "past skew is checked only while tailing the live log")
+}
+
+func TestMonitor__CloseSignals(t *testing.T) {
+	cases := []struct {
+		name         string
+		closeSignals []CloseSignal
+		expected     CloseSignal
+	}{
+		{
+			name:     "default signals",
+			expected: CloseSignalTerminateRequested,
+		},
+		{
+			name:         "session worker closed only",
+			closeSignals: []CloseSignal{CloseSignalSessionWorkerClosed},
+			expected:     CloseSignalSessionWorkerClosed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file, err := os.Open("testdata/amazon-ssm-agent.log")
+			require.NoError(t, err)
+			defer file.Close()
+			m := NewMonitorWithOptions("", MonitorOptions{CloseSignals: c.closeSignals})
+			require.NoError(t, m.RunWithReader(context.Background(), file))
+			signal, ok := m.ClosedBy("ecs-execute-command-03e391dc3f39b326a")
+			require.True(t, ok)
+			require.Equal(t, c.expected, signal)
+			_, ok = m.ClosedBy("ecs-execute-command-02f7755870b50f125")
+			require.False(t, ok)
+			require.Equal(t, 1, m.Metrics().ActiveConnections)
+		})
+	}
+}
+
+func TestMonitor__ErrorReopened(t *testing.T) {
+	logs := strings.Join([]string{
+		"2023-11-17 07:40:28 INFO [ssm-session-worker] [doc-1] Session worker parameters",
+		"2023-11-17 07:40:30 ERROR [ssm-session-worker] [doc-1] [DataBackend] [pluginName=Port] Unable to read from connection",
+	}, "\n") + "\n"
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	_, ok := m.ClosedBy("doc-1")
+	require.False(t, ok, "error is not a close signal by default")
+	require.Equal(t, 1, m.Metrics().ActiveConnections)
+
+	m = NewMonitorWithOptions("", MonitorOptions{CloseSignals: CloseSignals})
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	signal, ok := m.ClosedBy("doc-1")
+	require.True(t, ok)
+	require.Equal(t, CloseSignalError, signal)
+	require.Equal(t, 0, m.Metrics().ActiveConnections)
+
+	logs = "2023-11-17 07:40:31 INFO [ssm-session-worker] [doc-1] [DataBackend] [pluginName=Port] Connection accepted\n"
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	_, ok = m.ClosedBy("doc-1")
+	require.False(t, ok)
+	require.Equal(t, 1, m.Metrics().ActiveConnections)
+}
+
+func TestMonitor__IPCChannelRemoved(t *testing.T) {
+	channel := filepath.Join(t.TempDir(), "doc-1")
+	require.NoError(t, os.WriteFile(channel, nil, 0644))
+	logs := "2023-11-17 07:40:28 INFO [ssm-session-worker] [doc-1] inter process communication started at " + channel + "\n"
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	m.CheckIPCChannels()
+	require.Equal(t, 1, m.Metrics().ActiveConnections)
+
+	require.NoError(t, os.Remove(channel))
+	m.CheckIPCChannels()
+	require.Equal(t, 0, m.Metrics().ActiveConnections)
+	signal, ok := m.ClosedBy("doc-1")
+	require.True(t, ok)
+	require.Equal(t, CloseSignalIPCChannelRemoved, signal)
+}
+
+func TestMonitor__SessionInactivity(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	dir := t.TempDir()
+	transcript := filepath.Join(dir, "ecs-execute-command-1.log")
+	require.NoError(t, os.WriteFile(transcript, []byte("sh-5.2# "), 0644))
+	require.NoError(t, os.Chtimes(transcript, time.Time{}, time.Date(2023, 11, 17, 7, 50, 0, 0, time.UTC)))
+	logs := strings.Join([]string{
+		"2023-11-17 07:40:00 INFO [ssm-session-worker] [ecs-execute-command-1] Session worker parameters",
+		`2023-11-17 07:40:00 INFO [ssm-session-worker] [ecs-execute-command-1] [DataBackend] {"DocumentInformation":{"DocumentID":"ecs-execute-command-1","DocumentName":"AmazonECS-ExecuteInteractiveCommand"},"InstancePluginsInformation":[{"Name":"InteractiveCommands","Configuration":{"Properties":{"linux":{"commands":"sh"}},"OrchestrationDirectory":"` + dir + `"}}]}`,
+	}, "\n") + "\n"
+	m := NewMonitorWithOptions("", MonitorOptions{SessionInactivityTimeout: 30 * time.Minute})
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	m.CheckTranscripts()
+	lastIO, ok := m.LastIO("ecs-execute-command-1")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 50, 0, 0, time.UTC), lastIO)
+	metrics := m.Metrics()
+	require.Equal(t, 1, metrics.ActiveConnections)
+	require.Equal(t, 0, metrics.InactiveConnections)
+	require.Equal(t, lastIO, metrics.LastTimestamp)
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 21, 0, 0, time.UTC))
+	m.CheckTranscripts()
+	metrics = m.Metrics()
+	require.Equal(t, 1, metrics.ActiveConnections)
+	require.Equal(t, 1, metrics.InactiveConnections)
+	require.Equal(t, 1, metrics.ByType[SessionTypeExec].InactiveConnections)
+
+	require.NoError(t, os.Chtimes(transcript, time.Time{}, time.Date(2023, 11, 17, 8, 20, 0, 0, time.UTC)))
+	m.CheckTranscripts()
+	require.Equal(t, 0, m.Metrics().InactiveConnections)
+}
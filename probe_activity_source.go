@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// ProbeActivitySource asks the application whether it is busy every interval, a failed probe is not activity.
+type ProbeActivitySource struct {
+	name     string
+	interval time.Duration
+	// probe returns the time of the last activity, or zero if the application is not busy.
+	probe  func(ctx context.Context) (time.Time, error)
+	logger *slog.Logger
+
+	mu           sync.RWMutex
+	lastActivity time.Time
+	failing      bool
+}
+
+// NewHTTPProbeActivitySource polls url, and extracts a boolean of busy or a timestamp of the last activity at jsonPath of the response,
+// such as last_activity of /api/status of Jupyter. A 2xx response means busy if jsonPath is empty.
+func NewHTTPProbeActivitySource(url string, jsonPath string, interval time.Duration, client *http.Client, logger *slog.Logger) *ProbeActivitySource {
+	return &ProbeActivitySource{
+		name:     "http probe " + url,
+		interval: interval,
+		logger:   logger,
+		probe: func(ctx context.Context) (time.Time, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return time.Time{}, err
+			}
+			req.Header.Set("Accept", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return time.Time{}, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return time.Time{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+			if jsonPath == "" {
+				return flextime.Now(), nil
+			}
+			var body any
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				return time.Time{}, err
+			}
+			value, err := jsonPathValue(body, jsonPath)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return activityOf(value)
+		},
+	}
+}
+
+// NewExecProbeActivitySource runs command with sh every interval, exit 0 means busy.
+func NewExecProbeActivitySource(command string, interval time.Duration, logger *slog.Logger) *ProbeActivitySource {
+	return &ProbeActivitySource{
+		name:     "exec probe " + command,
+		interval: interval,
+		logger:   logger,
+		probe: func(ctx context.Context) (time.Time, error) {
+			err := exec.CommandContext(ctx, "sh", "-c", command).Run()
+			var exitErr *exec.ExitError
+			switch {
+			case err == nil:
+				return flextime.Now(), nil
+			case errors.As(err, &exitErr) && ctx.Err() == nil:
+				return time.Time{}, nil
+			default:
+				return time.Time{}, err
+			}
+		},
+	}
+}
+
+func (s *ProbeActivitySource) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.Probe(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe runs the probe once, with the interval as the timeout.
+func (s *ProbeActivitySource) Probe(ctx context.Context) {
+	probeCtx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
+	t, err := s.probe(probeCtx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if !s.failing && s.logger != nil && ctx.Err() == nil {
+			s.logger.WarnContext(ctx, "activity probe failed", "probe", s.name, "error", err)
+		}
+		s.failing = true
+		return
+	}
+	if s.failing && s.logger != nil {
+		s.logger.InfoContext(ctx, "activity probe recovered", "probe", s.name)
+	}
+	s.failing = false
+	if t.After(s.lastActivity) {
+		s.lastActivity = t
+	}
+}
+
+func (s *ProbeActivitySource) Name() string {
+	return s.name
+}
+
+func (s *ProbeActivitySource) LastActivity() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastActivity
+}
+
+// jsonPathValue returns the value at a dot separated path such as kernels.0.execution_state, a leading $. is allowed.
+func jsonPathValue(v any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return v, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			value, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("json path %s: %s is not found", path, key)
+			}
+			v = value
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("json path %s: index %s is out of range", path, key)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("json path %s: %s is not an object or array", path, key)
+		}
+	}
+	return v, nil
+}
+
+// activityOf interprets a value of the probe: true is busy, a string is an RFC3339 timestamp and a number is unix seconds of the last activity.
+func activityOf(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return flextime.Now(), nil
+		}
+		return time.Time{}, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case float64:
+		sec, frac := int64(v), v-float64(int64(v))
+		return time.Unix(sec, int64(frac*float64(time.Second))), nil
+	case nil:
+		return time.Time{}, nil
+	default:
+		return time.Time{}, fmt.Errorf("unexpected value of activity: %v", value)
+	}
+}
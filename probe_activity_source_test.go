@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProbeActivitySource(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	body := `{"last_activity": "2023-11-17T07:50:00.123456Z", "kernels": [{"busy": false}]}`
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	source := NewHTTPProbeActivitySource(server.URL, "last_activity", time.Second, http.DefaultClient, nil)
+	source.Probe(ctx)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 50, 0, 123456000, time.UTC), source.LastActivity())
+
+	source = NewHTTPProbeActivitySource(server.URL, "$.kernels.0.busy", time.Second, http.DefaultClient, nil)
+	source.Probe(ctx)
+	require.True(t, source.LastActivity().IsZero())
+	body = `{"kernels": [{"busy": true}]}`
+	source.Probe(ctx)
+	require.Equal(t, flextime.Now(), source.LastActivity())
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 1, 0, 0, time.UTC))
+	status = http.StatusServiceUnavailable
+	source.Probe(ctx)
+	require.Equal(t, time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC), source.LastActivity(), "a failed probe is not activity")
+
+	source = NewHTTPProbeActivitySource(server.URL, "", time.Second, http.DefaultClient, nil)
+	source.Probe(ctx)
+	require.True(t, source.LastActivity().IsZero())
+	status = http.StatusOK
+	source.Probe(ctx)
+	require.Equal(t, flextime.Now(), source.LastActivity())
+}
+
+func TestExecProbeActivitySource(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	source := NewExecProbeActivitySource("exit 1", time.Second, nil)
+	source.Probe(context.Background())
+	require.True(t, source.LastActivity().IsZero())
+
+	source = NewExecProbeActivitySource("test -n busy", time.Second, nil)
+	source.Probe(context.Background())
+	require.Equal(t, flextime.Now(), source.LastActivity())
+
+	source = NewExecProbeActivitySource("sleep 5", 100*time.Millisecond, nil)
+	source.Probe(context.Background())
+	require.True(t, source.LastActivity().IsZero(), "a timed out probe is not activity")
+}
+
+func TestJSONPathValue(t *testing.T) {
+	v := map[string]any{"a": []any{map[string]any{"b": true}}}
+	value, err := jsonPathValue(v, "a.0.b")
+	require.NoError(t, err)
+	require.Equal(t, true, value)
+	_, err = jsonPathValue(v, "a.1.b")
+	require.Error(t, err)
+	_, err = jsonPathValue(v, "c")
+	require.Error(t, err)
+
+	activity, err := activityOf(1700207400.5)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2023, 11, 17, 7, 50, 0, 500000000, time.UTC), activity.UTC())
+	_, err = activityOf("yesterday")
+	require.Error(t, err)
+}
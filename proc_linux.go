@@ -0,0 +1,147 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var procRoot = "/proc"
+
+// clockTicksPerSecond is USER_HZ, which is fixed at 100 for the values exposed in /proc.
+const clockTicksPerSecond = 100
+
+// processStartTimeTolerance absorbs the second precision of btime and the delay until ssm-agent-worker logs the start time.
+const processStartTimeTolerance = 5 * time.Second
+
+// isProcessAlive reports whether the process is running and started at startTime, so a reused PID is not mistaken for the session worker.
+// A zombie process that has exited but not been reaped yet is not alive.
+func isProcessAlive(pid int, startTime time.Time) (bool, error) {
+	state, started, err := processStat(pid)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if state == "Z" || state == "X" {
+		return false, nil
+	}
+	if startTime.IsZero() {
+		return true, nil
+	}
+	diff := started.Sub(startTime)
+	return diff.Abs() <= processStartTimeTolerance, nil
+}
+
+// processStat returns the state and the start time of the process from /proc/<pid>/stat.
+func processStat(pid int) (string, time.Time, error) {
+	bs, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	// comm in the second field may contain spaces and parentheses.
+	i := bytes.LastIndexByte(bs, ')')
+	if i < 0 {
+		return "", time.Time{}, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(bs[i+1:]))
+	// starttime is the 22nd field, fields start from the 3rd field.
+	if len(fields) < 20 {
+		return "", time.Time{}, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid starttime in /proc/%d/stat: %w", pid, err)
+	}
+	bootTime, err := bootTime()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return fields[0], bootTime.Add(time.Duration(ticks) * time.Second / clockTicksPerSecond), nil
+}
+
+func bootTime() (time.Time, error) {
+	file, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "btime ")
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid btime in /proc/stat: %w", err)
+		}
+		return time.Unix(sec, 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("btime not found in /proc/stat")
+}
+
+const (
+	sessionWorkerName = "ssm-session-worker"
+	coreAgentName     = "amazon-ssm-agent"
+)
+
+// listSessionWorkers returns the PIDs of running ssm-session-worker processes by document ID, which is the first argument of the worker.
+func listSessionWorkers() (map[string]int, error) {
+	processes, err := listProcesses(sessionWorkerName)
+	if err != nil {
+		return nil, err
+	}
+	workers := map[string]int{}
+	for pid, args := range processes {
+		for _, arg := range args[1:] {
+			if arg != "" && !strings.HasPrefix(arg, "-") {
+				workers[arg] = pid
+				break
+			}
+		}
+	}
+	return workers, nil
+}
+
+// listProcesses returns the arguments of running processes by PID, whose executable is named name.
+func listProcesses(name string) (map[int][]string, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	processes := map[int][]string{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		bs, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "cmdline"))
+		if err != nil {
+			// the process exited while scanning, or belongs to another user.
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(bs), "\x00"), "\x00")
+		if filepath.Base(args[0]) == name {
+			processes[pid] = args
+		}
+	}
+	return processes, nil
+}
+
+func isCoreAgentRunning() (bool, error) {
+	processes, err := listProcesses(coreAgentName)
+	return len(processes) > 0, err
+}
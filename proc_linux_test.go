@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/stretchr/testify/require"
+)
+
+func processStartTime(pid int) (time.Time, error) {
+	_, started, err := processStat(pid)
+	return started, err
+}
+
+func TestIsProcessAlive(t *testing.T) {
+	startTime, err := processStartTime(os.Getpid())
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), startTime, 10*time.Minute)
+
+	alive, err := isProcessAlive(os.Getpid(), startTime)
+	require.NoError(t, err)
+	require.True(t, alive)
+
+	alive, err = isProcessAlive(os.Getpid(), startTime.Add(-time.Hour))
+	require.NoError(t, err)
+	require.False(t, alive, "reused pid")
+
+	alive, err = isProcessAlive(exitedPid(t), time.Time{})
+	require.NoError(t, err)
+	require.False(t, alive)
+}
+
+func TestMonitor__CheckSessionProcesses(t *testing.T) {
+	startTime, err := processStartTime(os.Getpid())
+	require.NoError(t, err)
+	pluginConfig := `{"DocumentInformation":{"DocumentID":"%s","DocumentName":"AmazonECS-ExecuteInteractiveCommand","ProcInfo":{"Pid":%d,"StartTime":"%s"}}}`
+	logs := strings.Join([]string{
+		"2023-11-17 07:40:28 INFO [ssm-session-worker] [doc-alive] [DataBackend] " + fmt.Sprintf(pluginConfig, "doc-alive", os.Getpid(), startTime.Format(time.RFC3339Nano)),
+		"2023-11-17 07:40:28 INFO [ssm-session-worker] [doc-exited] [DataBackend] " + fmt.Sprintf(pluginConfig, "doc-exited", exitedPid(t), time.Now().Format(time.RFC3339Nano)),
+	}, "\n") + "\n"
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	require.Equal(t, 2, m.Metrics().ActiveConnections)
+
+	require.NoError(t, m.CheckSessionProcesses())
+	require.Equal(t, 1, m.Metrics().ActiveConnections)
+	signal, ok := m.ClosedBy("doc-exited")
+	require.True(t, ok)
+	require.Equal(t, CloseSignalProcessExited, signal)
+	_, ok = m.ClosedBy("doc-alive")
+	require.False(t, ok)
+}
+
+func TestMonitor__KillSessionCanceled(t *testing.T) {
+	cmd := exec.Command("sh", "-c", `trap "" TERM; echo ready; sleep 30`)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	_, err = stdout.Read(make([]byte, 1))
+	require.NoError(t, err)
+	startTime, err := processStartTime(cmd.Process.Pid)
+	require.NoError(t, err)
+
+	m := NewMonitor("")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	m.killSession(ctx, SessionInfo{DocumentID: "doc-ignore-term", Pid: cmd.Process.Pid, ProcessStartTime: startTime}, "test")
+	require.Less(t, time.Since(begin), sessionKillGracePeriod)
+	alive, err := isProcessAlive(cmd.Process.Pid, startTime)
+	require.NoError(t, err)
+	require.True(t, alive, "SIGKILL is not sent when ctx is done before the grace period")
+	_, ok := m.ClosedBy("doc-ignore-term")
+	require.False(t, ok)
+}
+
+func exitedPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func TestProcSource(t *testing.T) {
+	root := t.TempDir()
+	writeCmdline := func(pid int, args ...string) {
+		dir := filepath.Join(root, strconv.Itoa(pid))
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(args, "\x00")+"\x00"), 0644))
+	}
+	writeCmdline(1, "/usr/bin/amazon-ssm-agent")
+	writeCmdline(31, "/usr/bin/ssm-session-worker", "ecs-execute-command-03e391dc3f39b326a", "i-00000000000000000")
+	writeCmdline(64, "/managed-agents/execute-command/bin/3.2.1705.0/ssm-session-worker", "aws-go-sdk-1700206823550536000-0749df7ec4fc89a00", "i-00000000000000000")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "self"), 0755))
+	defer func(orig string) { procRoot = orig }(procRoot)
+	procRoot = root
+
+	workers, err := listSessionWorkers()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"ecs-execute-command-03e391dc3f39b326a":            31,
+		"aws-go-sdk-1700206823550536000-0749df7ec4fc89a00": 64,
+	}, workers)
+
+	s := NewProcSource(time.Second)
+	require.NoError(t, s.Scan())
+	metrics := s.Metrics()
+	require.Equal(t, 2, metrics.ActiveConnections)
+	require.Equal(t, 2, metrics.TotalConnections)
+	require.Equal(t, 1, metrics.ByType[SessionTypeExec].ActiveConnections)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "31")))
+	require.NoError(t, s.Scan())
+	metrics = s.Metrics()
+	require.Equal(t, 1, metrics.ActiveConnections)
+	require.Equal(t, 2, metrics.TotalConnections)
+	require.Equal(t, 0, metrics.ByType[SessionTypeExec].ActiveConnections)
+}
+
+func TestMonitor__EnforceSessionLimits(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	cmd := exec.Command("sleep", "60")
+	require.NoError(t, cmd.Start())
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	startTime, err := processStartTime(cmd.Process.Pid)
+	require.NoError(t, err)
+	pluginConfig := `{"DocumentInformation":{"DocumentID":"%s","DocumentName":"AmazonECS-ExecuteInteractiveCommand","ProcInfo":{"Pid":%d,"StartTime":"%s"}}}`
+	logs := strings.Join([]string{
+		"2023-11-17 07:40:00 INFO [ssm-session-worker] [doc-long] [DataBackend] " + fmt.Sprintf(pluginConfig, "doc-long", cmd.Process.Pid, startTime.Format(time.RFC3339Nano)),
+		"2023-11-17 07:59:00 INFO [ssm-session-worker] [doc-short] [DataBackend] " + fmt.Sprintf(pluginConfig, "doc-short", os.Getpid(), time.Now().Format(time.RFC3339Nano)),
+	}, "\n") + "\n"
+	m := NewMonitorWithOptions("", MonitorOptions{MaxSessionDuration: 10 * time.Minute})
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	require.Equal(t, 2, m.Metrics().ActiveConnections)
+
+	m.EnforceSessionLimits(context.Background())
+	select {
+	case err := <-exited:
+		require.ErrorContains(t, err, "terminated")
+	case <-time.After(5 * time.Second):
+		t.Fatal("session worker was not killed")
+	}
+	require.Eventually(t, func() bool {
+		signal, ok := m.ClosedBy("doc-long")
+		return ok && signal == CloseSignalKilled
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, m.Metrics().ActiveConnections)
+	_, ok := m.ClosedBy("doc-short")
+	require.False(t, ok)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+func isProcessAlive(int, time.Time) (bool, error) {
+	return false, errors.New("checking session worker process is not supported on this platform")
+}
+
+func listSessionWorkers() (map[string]int, error) {
+	return nil, errors.New("scanning session worker processes is not supported on this platform")
+}
+
+func isCoreAgentRunning() (bool, error) {
+	return false, errors.New("checking ssm agent process is not supported on this platform")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// ScanSource discovers sessions by periodically listing the document IDs of running sessions.
+type ScanSource struct {
+	name     string
+	interval time.Duration
+	// list returns the running sessions, and whether the listing covers all running sessions.
+	list           func() ([]string, bool, error)
+	onScan         func(running map[string]bool)
+	mu             sync.RWMutex
+	lastTimestamps map[string]time.Time
+	running        map[string]bool
+	metrics        Metrics
+}
+
+func newScanSource(name string, interval time.Duration, list func() ([]string, bool, error)) *ScanSource {
+	return &ScanSource{
+		name:           name,
+		interval:       interval,
+		list:           list,
+		lastTimestamps: map[string]time.Time{},
+		running:        map[string]bool{},
+	}
+}
+
+// NewProcSource discovers sessions from running ssm-session-worker processes, for SSM agents without file logging.
+func NewProcSource(interval time.Duration) *ScanSource {
+	return newScanSource(SessionSourceProc, interval, func() ([]string, bool, error) {
+		workers, err := listSessionWorkers()
+		if err != nil {
+			return nil, false, err
+		}
+		documentIDs := make([]string, 0, len(workers))
+		for documentID := range workers {
+			documentIDs = append(documentIDs, documentID)
+		}
+		return documentIDs, true, nil
+	})
+}
+
+// NewChannelsSource discovers sessions from the IPC channels that the SSM agent creates for each live session and removes on close.
+// channelsGlob matches the channels directories, such as /var/lib/amazon/ssm/*/channels.
+func NewChannelsSource(channelsGlob string, interval time.Duration) *ScanSource {
+	return newScanSource(SessionSourceChannels, interval, func() ([]string, bool, error) {
+		return listChannels(channelsGlob)
+	})
+}
+
+// listChannels returns the document IDs in the channels directories, and whether any channels directory was found.
+func listChannels(channelsGlob string) ([]string, bool, error) {
+	dirs, err := filepath.Glob(channelsGlob)
+	if err != nil {
+		return nil, false, err
+	}
+	var documentIDs []string
+	found := false
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, false, err
+		}
+		found = true
+		for _, entry := range entries {
+			documentIDs = append(documentIDs, entry.Name())
+		}
+	}
+	return documentIDs, found, nil
+}
+
+// OnScan registers a function called with the running sessions after each scan,
+// which is skipped while the listing does not cover all running sessions, such as before the channels directory is created.
+func (s *ScanSource) OnScan(fn func(running map[string]bool)) {
+	s.onScan = fn
+}
+
+func (s *ScanSource) Name() string {
+	return s.name
+}
+
+func (s *ScanSource) LastActivity() time.Time {
+	return lastActivityOf(s.Metrics())
+}
+
+func (s *ScanSource) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Scan(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ScanSource) Scan() error {
+	documentIDs, complete, err := s.list()
+	if err != nil {
+		return err
+	}
+	now := flextime.Now()
+	running := make(map[string]bool, len(documentIDs))
+	for _, documentID := range documentIDs {
+		running[documentID] = true
+	}
+	s.mu.Lock()
+	for documentID := range s.running {
+		s.running[documentID] = running[documentID]
+	}
+	for documentID := range running {
+		s.running[documentID] = true
+		s.lastTimestamps[documentID] = now
+	}
+	s.updateMetricsLocked()
+	s.mu.Unlock()
+	if s.onScan != nil && complete {
+		s.onScan(running)
+	}
+	return nil
+}
+
+func (s *ScanSource) updateMetricsLocked() {
+	metrics := Metrics{
+		ByType: map[SessionType]SessionTypeMetrics{},
+	}
+	for documentID, t := range s.lastTimestamps {
+		sessionType := SessionTypeFromDocumentID(documentID)
+		typeMetrics := metrics.ByType[sessionType]
+		if t.After(metrics.LastTimestamp) {
+			metrics.LastTimestamp = t
+		}
+		if t.After(typeMetrics.LastTimestamp) {
+			typeMetrics.LastTimestamp = t
+		}
+		metrics.TotalConnections++
+		typeMetrics.TotalConnections++
+		if s.running[documentID] {
+			metrics.ActiveConnections++
+			typeMetrics.ActiveConnections++
+		}
+		metrics.ByType[sessionType] = typeMetrics
+	}
+	s.metrics = metrics
+}
+
+func (s *ScanSource) Metrics() Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.metrics
+}
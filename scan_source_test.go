@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelsSource(t *testing.T) {
+	root := t.TempDir()
+	channels := filepath.Join(root, "00000000000000000000000000000000-000000000", "channels")
+	require.NoError(t, os.MkdirAll(filepath.Join(channels, "ecs-execute-command-02f7755870b50f125"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(channels, "aws-go-sdk-1700206823550536000-0749df7ec4fc89a00"), 0755))
+
+	s := NewChannelsSource(filepath.Join(root, "*", "channels"), time.Second)
+	var scanned map[string]bool
+	s.OnScan(func(running map[string]bool) {
+		scanned = running
+	})
+	require.NoError(t, s.Scan())
+	require.Len(t, scanned, 2)
+	metrics := s.Metrics()
+	require.Equal(t, 2, metrics.ActiveConnections)
+	require.Equal(t, 1, metrics.ByType[SessionTypeExec].ActiveConnections)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(channels, "ecs-execute-command-02f7755870b50f125")))
+	require.NoError(t, s.Scan())
+	metrics = s.Metrics()
+	require.Equal(t, 1, metrics.ActiveConnections)
+	require.Equal(t, 2, metrics.TotalConnections)
+	require.Equal(t, map[string]bool{"aws-go-sdk-1700206823550536000-0749df7ec4fc89a00": true}, scanned)
+
+	s = NewChannelsSource(filepath.Join(root, "missing", "channels"), time.Second)
+	require.NoError(t, s.Scan())
+	require.Equal(t, 0, s.Metrics().TotalConnections)
+}
+
+func TestMonitor__ReconcileChannels(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 7, 41, 0, 0, time.UTC))
+	defer restore()
+	logs := strings.Join([]string{
+		"2023-11-17 07:40:00 INFO [ssm-session-worker] [doc-stale] Session worker parameters",
+		"2023-11-17 07:40:00 INFO [ssm-session-worker] [doc-live] Session worker parameters",
+		"2023-11-17 07:40:50 INFO [ssm-session-worker] [doc-new] Session worker parameters",
+	}, "\n") + "\n"
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+	m.ReconcileChannels(map[string]bool{"doc-live": true})
+	require.Equal(t, 2, m.Metrics().ActiveConnections)
+	signal, ok := m.ClosedBy("doc-stale")
+	require.True(t, ok)
+	require.Equal(t, CloseSignalIPCChannelRemoved, signal)
+	_, ok = m.ClosedBy("doc-new")
+	require.False(t, ok, "within grace period to create the channel")
+}
+
+func TestMonitor__ReconcileChannelsWithoutChannelsDirectory(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 7, 41, 0, 0, time.UTC))
+	defer restore()
+	logs := "2023-11-17 07:40:00 INFO [ssm-session-worker] [doc-1] Session worker parameters\n"
+	m := NewMonitor("")
+	require.NoError(t, m.RunWithReader(context.Background(), strings.NewReader(logs)))
+
+	s := NewChannelsSource(filepath.Join(t.TempDir(), "*", "channels"), time.Second)
+	s.OnScan(m.ReconcileChannels)
+	require.NoError(t, s.Scan())
+	require.Equal(t, 1, m.Metrics().ActiveConnections, "not reconciled without any channels directory")
+	_, ok := m.ClosedBy("doc-1")
+	require.False(t, ok)
+}
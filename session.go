@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type SessionType string
+
+const (
+	SessionTypeUnknown     SessionType = "unknown"
+	SessionTypeExec        SessionType = "exec"
+	SessionTypePortForward SessionType = "port-forward"
+)
+
+var SessionTypes = []SessionType{
+	SessionTypeExec,
+	SessionTypePortForward,
+}
+
+func SessionTypeFromDocumentName(documentName string) SessionType {
+	switch {
+	case strings.HasPrefix(documentName, "AWS-StartPortForwardingSession"):
+		return SessionTypePortForward
+	case documentName == "AmazonECS-ExecuteInteractiveCommand", documentName == "AWS-StartInteractiveCommand":
+		return SessionTypeExec
+	default:
+		return SessionTypeUnknown
+	}
+}
+
+// SessionTypeFromDocumentID guesses the session type when the document name is not known.
+// ECS Exec sessions have the ecs-execute-command- prefix, while port forwarding sessions are named by the client.
+func SessionTypeFromDocumentID(documentID string) SessionType {
+	if strings.HasPrefix(documentID, "ecs-execute-command-") {
+		return SessionTypeExec
+	}
+	return SessionTypeUnknown
+}
+
+type SessionInfo struct {
+	DocumentID      string
+	DocumentName    string
+	Type            SessionType
+	SessionOwner    string
+	Command         string
+	Host            string
+	PortNumber      string
+	LocalPortNumber string
+	// Pid and ProcessStartTime identify the ssm-session-worker process of the session.
+	Pid              int
+	ProcessStartTime time.Time
+	// TranscriptPath is the log of the session input and output written by the InteractiveCommands plugin.
+	TranscriptPath string
+}
+
+// PluginConfig is the `[DataBackend] {"DocumentInformation":...}` message that ssm-session-worker logs when it receives the plugin config.
+type PluginConfig struct {
+	DocumentInformation struct {
+		DocumentID   string `json:"DocumentID"`
+		DocumentName string `json:"DocumentName"`
+		SessionOwner string `json:"SessionOwner"`
+		ProcInfo     struct {
+			Pid       int    `json:"Pid"`
+			StartTime string `json:"StartTime"`
+		} `json:"ProcInfo"`
+	} `json:"DocumentInformation"`
+	InstancePluginsInformation []struct {
+		Name          string `json:"Name"`
+		Configuration struct {
+			Properties             json.RawMessage `json:"Properties"`
+			OrchestrationDirectory string          `json:"OrchestrationDirectory"`
+		} `json:"Configuration"`
+	} `json:"InstancePluginsInformation"`
+}
+
+type pluginProperties struct {
+	Linux struct {
+		Commands json.RawMessage `json:"commands"`
+	} `json:"linux"`
+	Type            string `json:"type"`
+	Host            string `json:"host"`
+	PortNumber      string `json:"portNumber"`
+	LocalPortNumber string `json:"localPortNumber"`
+}
+
+func (c PluginConfig) SessionInfo() SessionInfo {
+	info := SessionInfo{
+		DocumentID:   c.DocumentInformation.DocumentID,
+		DocumentName: c.DocumentInformation.DocumentName,
+		Type:         SessionTypeFromDocumentName(c.DocumentInformation.DocumentName),
+		SessionOwner: c.DocumentInformation.SessionOwner,
+		Pid:          c.DocumentInformation.ProcInfo.Pid,
+	}
+	if startTime, err := time.Parse(time.RFC3339Nano, c.DocumentInformation.ProcInfo.StartTime); err == nil {
+		info.ProcessStartTime = startTime
+	}
+	for _, plugin := range c.InstancePluginsInformation {
+		if dir := plugin.Configuration.OrchestrationDirectory; dir != "" && plugin.Name == "InteractiveCommands" {
+			info.TranscriptPath = filepath.Join(dir, info.DocumentID+".log")
+		}
+		var props pluginProperties
+		if err := json.Unmarshal(plugin.Configuration.Properties, &props); err != nil {
+			continue
+		}
+		info.Command = decodeCommands(props.Linux.Commands)
+		info.Host = props.Host
+		info.PortNumber = props.PortNumber
+		info.LocalPortNumber = props.LocalPortNumber
+		if info.Type == SessionTypeUnknown && strings.HasSuffix(props.Type, "PortForwarding") {
+			info.Type = SessionTypePortForward
+		}
+	}
+	return info
+}
+
+func decodeCommands(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str
+	}
+	var strs []string
+	if err := json.Unmarshal(raw, &strs); err == nil {
+		return strings.Join(strs, "\n")
+	}
+	return ""
+}
+
+// CloseSignal is the evidence that a session was closed.
+type CloseSignal string
+
+const (
+	CloseSignalSessionWorkerClosed CloseSignal = "session-worker-closed"
+	CloseSignalTerminateRequested  CloseSignal = "terminate-requested"
+	CloseSignalExecuterClosed      CloseSignal = "executer-closed"
+	CloseSignalError               CloseSignal = "error"
+	CloseSignalIPCChannelRemoved   CloseSignal = "ipc-channel-removed"
+	CloseSignalProcessExited       CloseSignal = "process-exited"
+)
+
+var CloseSignals = []CloseSignal{
+	CloseSignalSessionWorkerClosed,
+	CloseSignalTerminateRequested,
+	CloseSignalExecuterClosed,
+	CloseSignalError,
+	CloseSignalIPCChannelRemoved,
+	CloseSignalProcessExited,
+}
+
+// DefaultCloseSignals are the signals enabled when --session-close-signals is empty.
+// error is opt-in, since ssm-session-worker logs errors of sessions that are still open, such as a failed connection of a port forwarding tunnel.
+var DefaultCloseSignals = []CloseSignal{
+	CloseSignalSessionWorkerClosed,
+	CloseSignalTerminateRequested,
+	CloseSignalExecuterClosed,
+	CloseSignalIPCChannelRemoved,
+	CloseSignalProcessExited,
+}
+
+// CloseSignalKilled is recorded when the session was killed by MaxSessionDuration or SessionKillInactivityTimeout, it is not selectable.
+const CloseSignalKilled CloseSignal = "killed"
+
+// IsWeak reports whether a later log line of the session worker reopens the session.
+func (s CloseSignal) IsWeak() bool {
+	return s == CloseSignalError
+}
+
+func ParseCloseSignals(names []string) ([]CloseSignal, error) {
+	signals := make([]CloseSignal, 0, len(names))
+	for _, name := range names {
+		signal := CloseSignal(name)
+		if !slices.Contains(CloseSignals, signal) {
+			return nil, fmt.Errorf("unknown session close signal: %s", name)
+		}
+		signals = append(signals, signal)
+	}
+	return signals, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const simulateEventBuffer = 100
+
+// RunSimulate replays a recorded SSM Agent Log through Monitor and the main loop with a virtual clock driven by the log timestamps,
+// and writes a timeline of sessions, state changes and when and why the task would have been stopped under the settings of cli.
+// Session checks based on /proc, IPC channels and transcripts and activity sources are not simulated, and the task is not actually stopped.
+func RunSimulate(ctx context.Context, w io.Writer, cli CLI) error {
+	if len(cli.Commands) > 0 {
+		return errors.New("simulate does not run commands")
+	}
+	app, err := newApp(cli)
+	if err != nil {
+		return err
+	}
+	return app.Simulate(ctx, w)
+}
+
+type simulation struct {
+	app      *App
+	tw       *tabwriter.Writer
+	events   <-chan SessionEvent
+	interval time.Duration
+	lastTick time.Time
+	state    loopState
+	reason   string
+}
+
+func (app *App) Simulate(ctx context.Context, w io.Writer) error {
+	paths, err := ExpandLogFiles(app.cli.SSMAgentLogHistory, app.cli.SSMAgentLogLocation)
+	if err != nil {
+		return fmt.Errorf("failed to expand ssm agent log history: %w", err)
+	}
+	paths = append(paths, app.cli.SSMAgentLogLocation)
+	_, err = app.simulate(ctx, w, paths)
+	return err
+}
+
+func (app *App) simulate(ctx context.Context, w io.Writer, paths []string) (*simulation, error) {
+	m := NewMonitorWithOptions(app.cli.SSMAgentLogLocation, MonitorOptions{
+		Parser:       app.logParser,
+		Logger:       app.logger,
+		CloseSignals: app.closeSignals,
+	})
+	app.monitor = m
+	events, unsubscribe := m.Subscribe(simulateEventBuffer)
+	defer unsubscribe()
+	sim := &simulation{
+		app:      app,
+		tw:       tabwriter.NewWriter(w, 0, 8, 2, ' ', 0),
+		events:   events,
+		interval: app.cli.MetricsCheckInterval,
+	}
+	if sim.interval <= 0 {
+		sim.interval = time.Second
+	}
+	restore := flextime.Fix(time.Time{})
+	defer restore()
+
+	fmt.Fprintln(sim.tw, "TIME\tELAPSED\tEVENT\tDETAIL\t")
+	var lastTimestamp time.Time
+	for _, path := range paths {
+		err := replayLogFile(ctx, path, app.logParser, func(e LogEntry) bool {
+			if sim.lastTick.IsZero() {
+				sim.start(e.Timestamp)
+			}
+			if sim.advance(ctx, e.Timestamp) {
+				return false
+			}
+			m.mark(e)
+			sim.drainEvents()
+			if e.Timestamp.After(lastTimestamp) {
+				lastTimestamp = e.Timestamp
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sim.reason != "" {
+			break
+		}
+	}
+	if sim.lastTick.IsZero() {
+		return nil, errors.New("no log entries to simulate")
+	}
+	if sim.reason == "" {
+		sim.advance(ctx, lastTimestamp.Add(sim.horizon()))
+	}
+	if sim.reason == "" {
+		sim.print(flextime.Now(), "end of simulation", fmt.Sprintf("task would still be running, %s", sim.state))
+	}
+	return sim, sim.tw.Flush()
+}
+
+// replayLogFile calls fn with the entries of the log file in order, until fn returns false.
+func replayLogFile(ctx context.Context, path string, parser LogParser, fn func(LogEntry) bool) error {
+	reader, err := OpenLogFile(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		e, ok, err := parser.Parse(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if ok && !fn(e) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (sim *simulation) start(t time.Time) {
+	sim.app.startAt = t
+	sim.lastTick = t
+	flextime.Fix(t)
+	sim.print(t, "task started", "")
+}
+
+// advance runs the main loop every interval until t, and reports whether the task was stopped.
+func (sim *simulation) advance(ctx context.Context, t time.Time) bool {
+	for tick := sim.lastTick.Add(sim.interval); !tick.After(t); tick = tick.Add(sim.interval) {
+		sim.lastTick = tick
+		flextime.Fix(tick)
+		state, reason := sim.app.check(ctx, sim.app.monitor)
+		if reason == "" && sim.app.cli.MaxLifeTime > 0 && flextime.Since(sim.app.startAt) > sim.app.cli.MaxLifeTime {
+			state, reason = loopStateStopped, context.DeadlineExceeded.Error()
+		}
+		if state == loopStateStopped {
+			sim.reason = reason
+			sim.print(tick, "task stopped", reason)
+			return true
+		}
+		if state != sim.state {
+			sim.state = state
+			sim.print(tick, "state changed", string(state))
+		}
+	}
+	if t.After(flextime.Now()) {
+		flextime.Fix(t)
+	}
+	return false
+}
+
+// horizon is how long to keep the clock running after the end of the log, long enough for any timeout to expire.
+func (sim *simulation) horizon() time.Duration {
+	cli := sim.app.cli
+	horizon := sim.app.initialWaitTime()
+	for _, d := range []time.Duration{cli.IdleTimeout, cli.ExecIdleTimeout, cli.PortForwardIdleTimeout, cli.AgentReadyTimeout} {
+		if d > horizon {
+			horizon = d
+		}
+	}
+	return horizon + 2*sim.interval
+}
+
+func (sim *simulation) drainEvents() {
+	for {
+		select {
+		case event := <-sim.events:
+			sim.printEvent(event)
+		default:
+			return
+		}
+	}
+}
+
+func (sim *simulation) printEvent(event SessionEvent) {
+	switch event.Type {
+	case SessionEventStarted:
+		sim.print(event.Time, "session started", fmt.Sprintf("%s (%s)", event.DocumentID, event.SessionType))
+	case SessionEventClosed:
+		detail := fmt.Sprintf("%s closed by %s", event.DocumentID, event.ClosedBy)
+		if event.StartedAt != nil {
+			detail += fmt.Sprintf(" after %s", event.Time.Sub(*event.StartedAt))
+		}
+		sim.print(event.Time, "session closed", detail)
+	case SessionEventAgentRestarted:
+		sim.print(event.Time, "agent restarted", "")
+	}
+}
+
+func (sim *simulation) print(t time.Time, event string, detail string) {
+	fmt.Fprintf(sim.tw, "%s\t+%s\t%s\t%s\t\n", t.Format(time.RFC3339), t.Sub(sim.app.startAt), event, detail)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSimulate(t *testing.T) {
+	cli := CLI{
+		SSMAgentLogLocation: "testdata/amazon-ssm-agent.log",
+		SSMAgentLogTimezone: "UTC",
+		LogLevel:            slog.LevelError,
+		InitialWaitTime:     30 * time.Minute,
+		IdleTimeout:         5 * time.Minute,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, RunSimulate(context.Background(), &buf, cli))
+	require.Contains(t, buf.String(), "2023-11-17T07:09:48Z  +1m0s    session started  ecs-execute-command-03e391dc3f39b326a (exec)")
+	require.Contains(t, buf.String(), "2023-11-17T07:28:36Z  +19m48s  task stopped     no active connections after idle timeout")
+
+	cli.IdleTimeout = 20 * time.Minute
+	buf.Reset()
+	require.NoError(t, RunSimulate(context.Background(), &buf, cli))
+	require.Contains(t, buf.String(), "task would still be running, active")
+	require.NotContains(t, buf.String(), "task stopped")
+
+	cli.MaxLifeTime = 10 * time.Minute
+	buf.Reset()
+	require.NoError(t, RunSimulate(context.Background(), &buf, cli))
+	require.Contains(t, buf.String(), "2023-11-17T07:18:49Z  +10m1s   task stopped     context deadline exceeded")
+}
+
+func TestRunSimulate__ActivitySources(t *testing.T) {
+	t.Setenv("ECS_CONTAINER_METADATA_URI_V4", "")
+	cli := CLI{
+		SSMAgentLogLocation:  "testdata/amazon-ssm-agent.log",
+		SSMAgentLogTimezone:  "UTC",
+		LogLevel:             slog.LevelError,
+		IdleTimeout:          5 * time.Minute,
+		ActivityCPUThreshold: 50,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, RunSimulate(context.Background(), &buf, cli), "activity sources are not created")
+	require.Contains(t, buf.String(), "task stopped")
+}
+
+func TestRunSimulate__NoEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "amazon-ssm-agent.log")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	err := RunSimulate(context.Background(), &bytes.Buffer{}, CLI{SSMAgentLogLocation: path, IdleTimeout: time.Minute})
+	require.EqualError(t, err, "no log entries to simulate")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type FileID struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+func (id FileID) IsZero() bool {
+	return id == FileID{}
+}
+
+type TailPosition struct {
+	FileID FileID `json:"file_id"`
+	Offset int64  `json:"offset"`
+}
+
+// State is the checkpoint written to --state-file, used to resume monitoring after the process restarts.
+type State struct {
+	// TaskARN identifies the task that wrote the state, a state of another task is discarded.
+	TaskARN               string                 `json:"task_arn"`
+	StartAt               time.Time              `json:"start_at"`
+	UpdatedAt             time.Time              `json:"updated_at"`
+	Position              TailPosition           `json:"position"`
+	LastTimestamps        map[string]time.Time   `json:"last_timestamps"`
+	IsSessionWorkerClosed map[string]bool        `json:"is_session_worker_closed"`
+	SessionInfos          map[string]SessionInfo `json:"session_infos"`
+	ClosedBy              map[string]CloseSignal `json:"closed_by,omitempty"`
+	IPCChannels           map[string]string      `json:"ipc_channels,omitempty"`
+	StartedAt             map[string]time.Time   `json:"started_at,omitempty"`
+	LastIO                map[string]time.Time   `json:"last_io,omitempty"`
+	Agent                 *AgentHealth           `json:"agent,omitempty"`
+}
+
+// LoadState returns nil State without error if the state file does not exist.
+func LoadState(path string) (*State, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(bs, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *State) Save(path string) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestState__SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := LoadState(path)
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	expected := &State{
+		TaskARN:   "arn:aws:ecs:ap-northeast-1:123456789012:task/default/0123456789abcdef0123456789abcdef",
+		StartAt:   time.Date(2023, 11, 17, 7, 8, 48, 0, time.UTC),
+		UpdatedAt: time.Date(2023, 11, 17, 7, 40, 40, 0, time.UTC),
+		Position: TailPosition{
+			FileID: FileID{Dev: 1, Ino: 2},
+			Offset: 1024,
+		},
+		LastTimestamps: map[string]time.Time{
+			"ecs-execute-command-03e391dc3f39b326a": time.Date(2023, 11, 17, 7, 10, 23, 0, time.UTC),
+		},
+		IsSessionWorkerClosed: map[string]bool{
+			"ecs-execute-command-03e391dc3f39b326a": true,
+		},
+		SessionInfos: map[string]SessionInfo{
+			"ecs-execute-command-03e391dc3f39b326a": {
+				DocumentID:   "ecs-execute-command-03e391dc3f39b326a",
+				DocumentName: "AmazonECS-ExecuteInteractiveCommand",
+				Type:         SessionTypeExec,
+				Command:      "sh",
+			},
+		},
+		LastIO: map[string]time.Time{
+			"ecs-execute-command-03e391dc3f39b326a": time.Date(2023, 11, 17, 7, 10, 20, 0, time.UTC),
+		},
+	}
+	require.NoError(t, expected.Save(path))
+	actual, err := LoadState(path)
+	require.NoError(t, err)
+	require.EqualValues(t, expected, actual)
+}
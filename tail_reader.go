@@ -1,45 +1,164 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sync"
 	"time"
 )
 
+const tailReaderHeadSize = 64
+
+type TailMode string
+
+const (
+	TailModeAuto    TailMode = "auto"
+	TailModeInotify TailMode = "inotify"
+	TailModePoll    TailMode = "poll"
+)
+
+type TailReaderOptions struct {
+	Mode TailMode
+	// StartPosition resumes reading from a checkpoint, if it points to the current file.
+	StartPosition *TailPosition
+}
+
+// fileWaiter blocks until the tailed file may have changed.
+type fileWaiter interface {
+	Watch(fileName string) error
+	Wait(ctx context.Context) error
+	Close() error
+}
+
 type TailReader struct {
-	mu         sync.Mutex
-	fileName   string
+	mu       sync.Mutex
+	fileName string
+	waiter   fileWaiter
+	// fallback switches the waiter to polling when watching the file fails, in auto mode.
+	fallback   bool
+	file       *os.File
+	fileInfo   os.FileInfo
+	head       []byte
 	currentPos int64
 	ctx        context.Context
+
+	posMu     sync.Mutex
+	streamPos int64
+	segments  []tailSegment
+	caughtUp  bool
+}
+
+// tailSegment maps the stream offset returned by Read to the file it was read from.
+type tailSegment struct {
+	fileID      FileID
+	streamStart int64
+	fileStart   int64
 }
 
+const maxTailSegments = 8
+
 func NewTailReader(fileName string) (*TailReader, error) {
 	return NewTailReaderWithContext(context.Background(), fileName)
 }
 
 func NewTailReaderWithContext(ctx context.Context, fileName string) (*TailReader, error) {
-	_, err := os.Stat(fileName)
+	return NewTailReaderWithOptions(ctx, fileName, TailReaderOptions{})
+}
+
+func NewTailReaderWithOptions(ctx context.Context, fileName string, opts TailReaderOptions) (*TailReader, error) {
+	waiter, err := newFileWaiter(opts.Mode)
 	if err != nil {
 		return nil, err
 	}
 	r := &TailReader{
 		fileName: fileName,
+		waiter:   waiter,
+		fallback: opts.Mode == TailModeAuto || opts.Mode == "",
 		ctx:      ctx,
 	}
+	if err := r.open(); err != nil {
+		waiter.Close()
+		return nil, err
+	}
+	if pos := opts.StartPosition; pos != nil && !pos.FileID.IsZero() && pos.FileID == fileIDOf(r.fileInfo) && pos.Offset <= r.fileInfo.Size() {
+		r.currentPos = pos.Offset
+		r.startSegment()
+	}
 	return r.WithContext(ctx), nil
 }
 
+func newFileWaiter(mode TailMode) (fileWaiter, error) {
+	switch mode {
+	case TailModePoll:
+		return pollWaiter{}, nil
+	case TailModeInotify:
+		return newInotifyWaiter()
+	case TailModeAuto, "":
+		if waiter, err := newInotifyWaiter(); err == nil {
+			return waiter, nil
+		}
+		return pollWaiter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown tail mode: %s", mode)
+	}
+}
+
 func (r *TailReader) WithContext(ctx context.Context) *TailReader {
 	cloned := &TailReader{
 		fileName:   r.fileName,
+		waiter:     r.waiter,
+		fallback:   r.fallback,
+		file:       r.file,
+		fileInfo:   r.fileInfo,
+		head:       r.head,
 		currentPos: r.currentPos,
+		streamPos:  r.streamPos,
+		segments:   r.segments,
 	}
 	cloned.ctx = ctx
 	return cloned
 }
 
+// Position returns the file position of the given offset in the stream returned by Read.
+func (r *TailReader) Position(streamOffset int64) TailPosition {
+	r.posMu.Lock()
+	defer r.posMu.Unlock()
+	for i := len(r.segments) - 1; i >= 0; i-- {
+		seg := r.segments[i]
+		if seg.streamStart <= streamOffset {
+			return TailPosition{
+				FileID: seg.fileID,
+				Offset: seg.fileStart + streamOffset - seg.streamStart,
+			}
+		}
+	}
+	return TailPosition{}
+}
+
+// CaughtUp reports whether the reader has reached the end of the file at least once.
+func (r *TailReader) CaughtUp() bool {
+	r.posMu.Lock()
+	defer r.posMu.Unlock()
+	return r.caughtUp
+}
+
+func (r *TailReader) startSegment() {
+	r.posMu.Lock()
+	defer r.posMu.Unlock()
+	r.segments = append(r.segments, tailSegment{
+		fileID:      fileIDOf(r.fileInfo),
+		streamStart: r.streamPos,
+		fileStart:   r.currentPos,
+	})
+	if len(r.segments) > maxTailSegments {
+		r.segments = r.segments[len(r.segments)-maxTailSegments:]
+	}
+}
+
 func (r *TailReader) Context() context.Context {
 	return r.ctx
 }
@@ -53,30 +172,163 @@ func (r *TailReader) Read(p []byte) (int, error) {
 			return 0, r.ctx.Err()
 		default:
 		}
-		stats, err := os.Stat(r.fileName)
-		if err != nil {
+		if r.file == nil {
+			if err := r.open(); err != nil {
+				if os.IsNotExist(err) {
+					r.wait()
+					continue
+				}
+				return 0, err
+			}
+		}
+		if err := r.detectTruncation(); err != nil {
 			return 0, err
 		}
-		if stats.Size() <= r.currentPos {
-			time.Sleep(500 * time.Millisecond)
-			continue
+		n, err := r.readAt(p)
+		if n > 0 || err != nil {
+			return n, err
 		}
-		file, err := os.Open(r.fileName)
+		r.posMu.Lock()
+		r.caughtUp = true
+		r.posMu.Unlock()
+		switched, err := r.detectRotation()
 		if err != nil {
 			return 0, err
 		}
-		_, err = file.Seek(r.currentPos, io.SeekStart)
-		if err != nil {
-			return 0, err
+		if switched {
+			continue
 		}
-		n, err := file.Read(p)
-		if err != nil {
-			return 0, err
+		r.wait()
+	}
+}
+
+func (r *TailReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.waiter.Close()
+	if r.file == nil {
+		return err
+	}
+	if closeErr := r.file.Close(); closeErr != nil {
+		err = closeErr
+	}
+	r.file = nil
+	return err
+}
+
+func (r *TailReader) open() error {
+	if err := r.waiter.Watch(r.fileName); err != nil {
+		if !r.fallback {
+			return err
 		}
-		if err := file.Close(); err != nil {
-			return 0, err
+		// such as ENOSPC when the inotify watch limit is reached.
+		r.waiter.Close()
+		r.waiter = pollWaiter{}
+		r.fallback = false
+	}
+	file, err := os.Open(r.fileName)
+	if err != nil {
+		return err
+	}
+	stats, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.fileInfo = stats
+	r.currentPos = 0
+	r.startSegment()
+	r.head, err = r.readHead()
+	return err
+}
+
+func (r *TailReader) readAt(p []byte) (int, error) {
+	n, err := r.file.ReadAt(p, r.currentPos)
+	r.currentPos += int64(n)
+	r.posMu.Lock()
+	r.streamPos += int64(n)
+	r.posMu.Unlock()
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *TailReader) readHead() ([]byte, error) {
+	head := make([]byte, tailReaderHeadSize)
+	n, err := r.file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// detectRotation reports whether the log file was replaced by a new file (rename + create).
+// The remainder of the old file is drained before switching to the new one.
+func (r *TailReader) detectRotation() (bool, error) {
+	stats, err := os.Stat(r.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
 		}
-		r.currentPos += int64(n)
-		return n, nil
+		return false, err
+	}
+	if os.SameFile(stats, r.fileInfo) {
+		return false, nil
 	}
+	if oldStats, err := r.file.Stat(); err == nil && oldStats.Size() > r.currentPos {
+		return true, nil
+	}
+	if err := r.file.Close(); err != nil {
+		return false, err
+	}
+	r.file = nil
+	if err := r.open(); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// detectTruncation rewinds to the start of the file when it was truncated in place (copytruncate).
+// A shrinking size or a changed head of the file are treated as truncation.
+func (r *TailReader) detectTruncation() error {
+	stats, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	head, err := r.readHead()
+	if err != nil {
+		return err
+	}
+	if stats.Size() < r.currentPos || !bytes.HasPrefix(head, r.head) {
+		r.currentPos = 0
+		r.startSegment()
+	}
+	r.head = head
+	return nil
+}
+
+func (r *TailReader) wait() {
+	if err := r.waiter.Wait(r.ctx); err != nil {
+		pollWaiter{}.Wait(r.ctx)
+	}
+}
+
+type pollWaiter struct{}
+
+func (pollWaiter) Watch(string) error {
+	return nil
+}
+
+func (pollWaiter) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+	case <-time.After(500 * time.Millisecond):
+	}
+	return nil
+}
+
+func (pollWaiter) Close() error {
+	return nil
 }
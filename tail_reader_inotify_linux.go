@@ -0,0 +1,125 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	inotifyFileMask = syscall.IN_MODIFY | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+	inotifyDirMask  = syscall.IN_CREATE | syscall.IN_MOVED_TO
+	// inotifySafetyInterval bounds a single wait, in case an event was missed.
+	inotifySafetyInterval = 1 * time.Minute
+)
+
+type inotifyWaiter struct {
+	mu       sync.Mutex
+	fd       int
+	file     *os.File
+	fileName string
+	fileWd   int
+	dirWd    int
+}
+
+func newInotifyWaiter() (fileWaiter, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	return &inotifyWaiter{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fileWd: -1,
+		dirWd:  -1,
+	}, nil
+}
+
+func (w *inotifyWaiter) Watch(fileName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fd := w.fd
+	if w.fileName != fileName {
+		if w.dirWd >= 0 {
+			syscall.InotifyRmWatch(fd, uint32(w.dirWd))
+		}
+		dirWd, err := syscall.InotifyAddWatch(fd, filepath.Dir(fileName), inotifyDirMask)
+		if err != nil {
+			return fmt.Errorf("inotify add watch: %w", err)
+		}
+		w.dirWd = dirWd
+		w.fileName = fileName
+	}
+	if w.fileWd >= 0 {
+		syscall.InotifyRmWatch(fd, uint32(w.fileWd))
+		w.fileWd = -1
+	}
+	fileWd, err := syscall.InotifyAddWatch(fd, fileName, inotifyFileMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("inotify add watch: %w", err)
+	}
+	w.fileWd = fileWd
+	return nil
+}
+
+func (w *inotifyWaiter) Wait(ctx context.Context) error {
+	if err := w.file.SetReadDeadline(time.Now().Add(inotifySafetyInterval)); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		w.file.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	var buf [4096]byte
+	for {
+		n, err := w.file.Read(buf[:])
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+		if w.hasRelevantEvent(buf[:n]) {
+			return nil
+		}
+	}
+}
+
+func (w *inotifyWaiter) hasRelevantEvent(buf []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	base := filepath.Base(w.fileName)
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+		offset = nameEnd
+		if int(event.Wd) != w.dirWd {
+			return true
+		}
+		if nameEnd > len(buf) {
+			return true
+		}
+		name := strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00")
+		if name == base {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *inotifyWaiter) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func newInotifyWaiter() (fileWaiter, error) {
+	return nil, errors.New("inotify is not supported on this platform")
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -26,3 +32,131 @@ func TestTailReader(t *testing.T) {
 	require.Equal(t, len(bs), n)
 	require.EqualValues(t, bs, actual)
 }
+
+func readTail(t *testing.T, reader *TailReader, size int) string {
+	t.Helper()
+	actual := make([]byte, 0, size)
+	buf := make([]byte, size)
+	for len(actual) < size {
+		n, err := reader.Read(buf[:size-len(actual)])
+		require.NoError(t, err)
+		actual = append(actual, buf[:n]...)
+	}
+	return string(actual)
+}
+
+func testTailModes(t *testing.T, f func(t *testing.T, mode TailMode)) {
+	for _, mode := range []TailMode{TailModePoll, TailModeInotify} {
+		t.Run(string(mode), func(t *testing.T) {
+			if mode == TailModeInotify {
+				waiter, err := newInotifyWaiter()
+				if err != nil {
+					t.Skip(err)
+				}
+				waiter.Close()
+			}
+			f(t, mode)
+		})
+	}
+}
+
+func TestTailReader__Rotate(t *testing.T) {
+	testTailModes(t, func(t *testing.T, mode TailMode) {
+		dir := t.TempDir()
+		fileName := filepath.Join(dir, "amazon-ssm-agent.log")
+		file, err := os.Create(fileName)
+		require.NoError(t, err)
+		defer file.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		reader, err := NewTailReaderWithOptions(ctx, fileName, TailReaderOptions{Mode: mode})
+		require.NoError(t, err)
+		defer reader.Close()
+
+		fmt.Fprintln(file, "first line")
+		require.Equal(t, "first line\n", readTail(t, reader, len("first line\n")))
+
+		require.NoError(t, os.Rename(fileName, fileName+".1"))
+		fmt.Fprintln(file, "written after rotate")
+		rotated, err := os.Create(fileName)
+		require.NoError(t, err)
+		defer rotated.Close()
+		fmt.Fprintln(rotated, "new file line")
+
+		expected := "written after rotate\nnew file line\n"
+		require.Equal(t, expected, readTail(t, reader, len(expected)))
+	})
+}
+
+func TestTailReader__Truncate(t *testing.T) {
+	testTailModes(t, func(t *testing.T, mode TailMode) {
+		dir := t.TempDir()
+		fileName := filepath.Join(dir, "amazon-ssm-agent.log")
+		file, err := os.Create(fileName)
+		require.NoError(t, err)
+		defer file.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		reader, err := NewTailReaderWithOptions(ctx, fileName, TailReaderOptions{Mode: mode})
+		require.NoError(t, err)
+		defer reader.Close()
+
+		fmt.Fprintln(file, "2023-11-17 07:08:48 INFO before truncate")
+		readTail(t, reader, len("2023-11-17 07:08:48 INFO before truncate\n"))
+
+		require.NoError(t, file.Truncate(0))
+		_, err = file.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		expected := "2023-11-17 08:00:00 INFO after truncate, longer than before\n"
+		fmt.Fprint(file, expected)
+		require.Equal(t, expected, readTail(t, reader, len(expected)))
+	})
+}
+
+func TestTailReader__InotifyWakeup(t *testing.T) {
+	testTailModes(t, func(t *testing.T, mode TailMode) {
+		if mode == TailModePoll {
+			t.Skip("polling waits up to 500ms")
+		}
+		dir := t.TempDir()
+		fileName := filepath.Join(dir, "amazon-ssm-agent.log")
+		file, err := os.Create(fileName)
+		require.NoError(t, err)
+		defer file.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		reader, err := NewTailReaderWithOptions(ctx, fileName, TailReaderOptions{Mode: mode})
+		require.NoError(t, err)
+		defer reader.Close()
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintln(file, "hello")
+		}()
+		start := time.Now()
+		require.Equal(t, "hello\n", readTail(t, reader, len("hello\n")))
+		require.Less(t, time.Since(start), 400*time.Millisecond)
+	})
+}
+
+type failingWatchWaiter struct {
+	pollWaiter
+}
+
+func (failingWatchWaiter) Watch(string) error {
+	return errors.New("inotify add watch: no space left on device")
+}
+
+func TestTailReader__FallbackOnWatchError(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "amazon-ssm-agent.log")
+	require.NoError(t, os.WriteFile(fileName, []byte("hello\n"), 0644))
+
+	reader := &TailReader{fileName: fileName, waiter: failingWatchWaiter{}, ctx: context.Background()}
+	require.Error(t, reader.open(), "inotify mode does not fall back")
+
+	reader = &TailReader{fileName: fileName, waiter: failingWatchWaiter{}, fallback: true, ctx: context.Background()}
+	require.NoError(t, reader.open())
+	defer reader.Close()
+	require.Equal(t, pollWaiter{}, reader.waiter)
+	require.Equal(t, "hello\n", readTail(t, reader, len("hello\n")))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// TaskStatsActivitySource samples CPU and network bytes of the containers from /task/stats of the task metadata endpoint v4,
+// and records activity while the utilization is above the thresholds, such as a batch job detached from an ECS Exec session.
+type TaskStatsActivitySource struct {
+	url string
+	// cpuThreshold is percent of one vCPU, and networkThreshold is bytes per second. Zero disables the threshold.
+	cpuThreshold     float64
+	networkThreshold int64
+	interval         time.Duration
+	client           *http.Client
+	logger           *slog.Logger
+
+	mu           sync.RWMutex
+	last         *taskStatsSample
+	lastActivity time.Time
+	cpu          float64
+	network      float64
+	failing      bool
+}
+
+type taskStatsSample struct {
+	at time.Time
+	// cpuUsage is the total CPU time of the containers in nanoseconds.
+	cpuUsage uint64
+	netBytes uint64
+}
+
+// containerStats is the part of the Docker stats of a container in /task/stats.
+type containerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+func NewTaskStatsActivitySource(metadataURL string, cpuThreshold float64, networkThreshold int64, interval time.Duration, client *http.Client, logger *slog.Logger) (*TaskStatsActivitySource, error) {
+	if metadataURL == "" {
+		return nil, errors.New("--activity-cpu-threshold and --activity-network-threshold require ECS_CONTAINER_METADATA_URI_V4")
+	}
+	if cpuThreshold < 0 || networkThreshold < 0 {
+		return nil, errors.New("activity thresholds must not be negative")
+	}
+	u, err := url.Parse(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskStatsActivitySource{
+		url:              u.JoinPath("/task/stats").String(),
+		cpuThreshold:     cpuThreshold,
+		networkThreshold: networkThreshold,
+		interval:         interval,
+		client:           client,
+		logger:           logger,
+	}, nil
+}
+
+func (s *TaskStatsActivitySource) Name() string {
+	return "activity task stats"
+}
+
+func (s *TaskStatsActivitySource) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.Sample(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample fetches the task stats once, and compares them with the previous sample.
+func (s *TaskStatsActivitySource) Sample(ctx context.Context) {
+	fetchCtx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
+	sample, err := s.fetch(fetchCtx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if !s.failing && s.logger != nil && ctx.Err() == nil {
+			s.logger.WarnContext(ctx, "failed to sample task stats", "error", err)
+		}
+		s.failing = true
+		return
+	}
+	if s.failing && s.logger != nil {
+		s.logger.InfoContext(ctx, "sampling task stats recovered")
+	}
+	s.failing = false
+	last := s.last
+	s.last = &sample
+	// the totals decrease when a container stops or restarts.
+	if last == nil || !sample.at.After(last.at) || sample.cpuUsage < last.cpuUsage || sample.netBytes < last.netBytes {
+		return
+	}
+	elapsed := sample.at.Sub(last.at).Seconds()
+	s.cpu = float64(sample.cpuUsage-last.cpuUsage) / float64(time.Second) / elapsed * 100
+	s.network = float64(sample.netBytes-last.netBytes) / elapsed
+	if s.logger != nil {
+		s.logger.DebugContext(ctx, "task stats", "cpu_percent", s.cpu, "network_bytes_per_second", s.network)
+	}
+	if (s.cpuThreshold > 0 && s.cpu >= s.cpuThreshold) || (s.networkThreshold > 0 && s.network >= float64(s.networkThreshold)) {
+		s.lastActivity = sample.at
+	}
+}
+
+func (s *TaskStatsActivitySource) fetch(ctx context.Context) (taskStatsSample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return taskStatsSample{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return taskStatsSample{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return taskStatsSample{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	// stats of stopped containers are null.
+	var stats map[string]*containerStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return taskStatsSample{}, err
+	}
+	sample := taskStatsSample{at: flextime.Now()}
+	// the network interfaces of awsvpc tasks are shared by the containers, so each interface is counted once.
+	interfaces := map[string]uint64{}
+	for _, container := range stats {
+		if container == nil {
+			continue
+		}
+		sample.cpuUsage += container.CPUStats.CPUUsage.TotalUsage
+		for name, network := range container.Networks {
+			interfaces[name] = max(interfaces[name], network.RxBytes+network.TxBytes)
+		}
+	}
+	for _, bytes := range interfaces {
+		sample.netBytes += bytes
+	}
+	return sample, nil
+}
+
+func (s *TaskStatsActivitySource) LastActivity() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastActivity
+}
+
+// Utilization returns CPU in percent of one vCPU and network in bytes per second between the last two samples.
+func (s *TaskStatsActivitySource) Utilization() (float64, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cpu, s.network
+}
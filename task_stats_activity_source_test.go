@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskStatsActivitySource(t *testing.T) {
+	restore := flextime.Fix(time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC))
+	defer restore()
+	var cpuUsage, netBytes uint64
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v4/abc/task/stats", r.URL.Path)
+		w.WriteHeader(status)
+		// the sidecar shares the network interface of the task, and the stopped container is null.
+		fmt.Fprintf(w, `{
+			"app": {"cpu_stats": {"cpu_usage": {"total_usage": %d}}, "networks": {"eth1": {"rx_bytes": %d, "tx_bytes": 0}}},
+			"sidecar": {"cpu_stats": {"cpu_usage": {"total_usage": 0}}, "networks": {"eth1": {"rx_bytes": %d, "tx_bytes": 0}}},
+			"init": null
+		}`, cpuUsage, netBytes, netBytes)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	source, err := NewTaskStatsActivitySource(server.URL+"/v4/abc", 50, 1000, time.Second, http.DefaultClient, nil)
+	require.NoError(t, err)
+	source.Sample(ctx)
+	require.True(t, source.LastActivity().IsZero(), "the first sample has no utilization")
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 0, 10, 0, time.UTC))
+	cpuUsage += uint64(time.Second)
+	netBytes += 5000
+	source.Sample(ctx)
+	cpu, network := source.Utilization()
+	require.InDelta(t, 10, cpu, 0.001)
+	require.InDelta(t, 500, network, 0.001)
+	require.True(t, source.LastActivity().IsZero())
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 0, 20, 0, time.UTC))
+	cpuUsage += uint64(8 * time.Second)
+	source.Sample(ctx)
+	require.Equal(t, flextime.Now(), source.LastActivity(), "cpu is above the threshold")
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 0, 30, 0, time.UTC))
+	netBytes += 20000
+	source.Sample(ctx)
+	require.Equal(t, flextime.Now(), source.LastActivity(), "network is above the threshold")
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 0, 40, 0, time.UTC))
+	status = http.StatusInternalServerError
+	cpuUsage += uint64(8 * time.Second)
+	source.Sample(ctx)
+	require.Equal(t, time.Date(2023, 11, 17, 8, 0, 30, 0, time.UTC), source.LastActivity(), "a failed sample is not activity")
+
+	flextime.Fix(time.Date(2023, 11, 17, 8, 0, 50, 0, time.UTC))
+	status = http.StatusOK
+	cpuUsage = 0
+	source.Sample(ctx)
+	require.Equal(t, time.Date(2023, 11, 17, 8, 0, 30, 0, time.UTC), source.LastActivity(), "decreased totals of a restarted container are not activity")
+
+	_, err = NewTaskStatsActivitySource("", 50, 0, time.Second, http.DefaultClient, nil)
+	require.Error(t, err)
+	_, err = NewTaskStatsActivitySource(server.URL, -1, 0, time.Second, http.DefaultClient, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+// tcpStateEstablished is the st column of /proc/net/tcp for ESTABLISHED connections.
+const tcpStateEstablished = "01"
+
+var procNetTCPPaths = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// TCPActivitySource scans /proc/net/tcp and /proc/net/tcp6, and records activity while connections to the local ports are established.
+type TCPActivitySource struct {
+	ports    map[uint16]bool
+	excludes []netip.Prefix
+	interval time.Duration
+	paths    []string
+	logger   *slog.Logger
+
+	mu           sync.RWMutex
+	lastActivity time.Time
+	connections  int
+}
+
+// NewTCPActivitySource creates a source of connections to ports, excluding connections from excludeCIDRs such as health checks of load balancers.
+func NewTCPActivitySource(ports []int, excludeCIDRs []string, interval time.Duration, logger *slog.Logger) (*TCPActivitySource, error) {
+	s := &TCPActivitySource{
+		ports:    make(map[uint16]bool, len(ports)),
+		interval: interval,
+		paths:    procNetTCPPaths,
+		logger:   logger,
+	}
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid activity tcp port: %d", port)
+		}
+		s.ports[uint16(port)] = true
+	}
+	for _, cidr := range excludeCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid activity tcp exclude cidr: %w", err)
+		}
+		s.excludes = append(s.excludes, prefix.Masked())
+	}
+	return s, nil
+}
+
+func (s *TCPActivitySource) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Scan(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *TCPActivitySource) Scan() error {
+	connections := 0
+	for _, path := range s.paths {
+		f, err := os.Open(path)
+		if err != nil {
+			// tcp6 does not exist when IPv6 is disabled.
+			if os.IsNotExist(err) && strings.HasSuffix(path, "6") {
+				continue
+			}
+			return err
+		}
+		n, err := s.countEstablished(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		connections += n
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if connections != s.connections && s.logger != nil {
+		s.logger.Debug("established tcp connections changed", "connections", connections)
+	}
+	s.connections = connections
+	if connections > 0 {
+		s.lastActivity = flextime.Now()
+	}
+	return nil
+}
+
+func (s *TCPActivitySource) countEstablished(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	count := 0
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// the header line is "sl local_address rem_address st ...".
+		if len(fields) < 4 || fields[0] == "sl" || fields[3] != tcpStateEstablished {
+			continue
+		}
+		local, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			return 0, err
+		}
+		if !s.ports[local.Port()] {
+			continue
+		}
+		remote, err := parseProcNetAddr(fields[2])
+		if err != nil {
+			return 0, err
+		}
+		if s.excluded(remote.Addr()) {
+			continue
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
+
+func (s *TCPActivitySource) excluded(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.excludes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProcNetAddr parses an address of /proc/net/tcp such as 0100007F:1F90,
+// where the IP address is hex of 32-bit words in host byte order (little endian) and the port is hex.
+func parseProcNetAddr(str string) (netip.AddrPort, error) {
+	hexIP, hexPort, ok := strings.Cut(str, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid address: %s", str)
+	}
+	ip, err := hex.DecodeString(hexIP)
+	if err != nil || (len(ip) != 4 && len(ip) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("invalid address: %s", str)
+	}
+	for i := 0; i < len(ip); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(ip[i:]))
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address: %s", str)
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+func (s *TCPActivitySource) Name() string {
+	return "activity tcp"
+}
+
+func (s *TCPActivitySource) LastActivity() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastActivity
+}
+
+// Connections returns the number of established connections at the last scan.
+func (s *TCPActivitySource) Connections() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connections
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcNetAddr(t *testing.T) {
+	addr, err := parseProcNetAddr("0100007F:1F90")
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("127.0.0.1:8080"), addr)
+	addr, err = parseProcNetAddr("0000000000000000FFFF00000500000A:C350")
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("[::ffff:10.0.0.5]:50000"), addr)
+	_, err = parseProcNetAddr("0100007F")
+	require.Error(t, err)
+}
+
+func TestTCPActivitySource(t *testing.T) {
+	dir := t.TempDir()
+	header := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	tcp := header + strings.Join([]string{
+		// listening on 5432
+		"   0: 00000000:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1 1 0000000000000000 100 0 0 10 0",
+		// 10.0.0.5 to 5432
+		"   1: 0200000A:1538 0500000A:C350 01 00000000:00000000 00:00000000 00000000   999        0 2 1 0000000000000000 20 4 30 10 -1",
+		// health check from 10.0.0.100
+		"   2: 0200000A:1538 6400000A:C351 01 00000000:00000000 00:00000000 00000000   999        0 3 1 0000000000000000 20 4 30 10 -1",
+		// ssh is not configured
+		"   3: 0200000A:0016 0500000A:C352 01 00000000:00000000 00:00000000 00000000     0        0 4 1 0000000000000000 20 4 30 10 -1",
+		// closing
+		"   4: 0200000A:1538 0500000A:C354 06 00000000:00000000 00:00000000 00000000     0        0 0 3 0000000000000000",
+	}, "\n") + "\n"
+	tcp6 := header + strings.Join([]string{
+		"   0: 00000000000000000000000001000000:1538 0000000000000000FFFF00000500000A:C353 01 00000000:00000000 00:00000000 00000000   999        0 5 1 0000000000000000 20 4 30 10 -1",
+		"   1: 00000000000000000000000001000000:1538 0000000000000000FFFF00006400000A:C355 01 00000000:00000000 00:00000000 00000000   999        0 6 1 0000000000000000 20 4 30 10 -1",
+	}, "\n") + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tcp"), []byte(tcp), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tcp6"), []byte(tcp6), 0644))
+
+	source, err := NewTCPActivitySource([]int{5432}, []string{"10.0.0.96/27"}, 0, nil)
+	require.NoError(t, err)
+	source.paths = []string{filepath.Join(dir, "tcp"), filepath.Join(dir, "tcp6")}
+	require.NoError(t, source.Scan())
+	require.Equal(t, 2, source.Connections())
+	require.False(t, source.LastActivity().IsZero())
+
+	source, err = NewTCPActivitySource([]int{3306}, nil, 0, nil)
+	require.NoError(t, err)
+	source.paths = []string{filepath.Join(dir, "tcp"), filepath.Join(dir, "missing-tcp6")}
+	require.NoError(t, source.Scan())
+	require.Equal(t, 0, source.Connections())
+	require.True(t, source.LastActivity().IsZero())
+
+	_, err = NewTCPActivitySource([]int{70000}, nil, 0, nil)
+	require.Error(t, err)
+	_, err = NewTCPActivitySource([]int{5432}, []string{"10.0.0.0"}, 0, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+)
+
+const tcpProxyDialTimeout = 10 * time.Second
+
+// TCPProxySource forwards TCP connections to the upstream, such as a database run by the wrapped command.
+// Open connections are active connections, and the last byte transferred is the last timestamp.
+type TCPProxySource struct {
+	listen string
+	target string
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	metrics Metrics
+}
+
+func NewTCPProxySource(listen string, target string, logger *slog.Logger) (*TCPProxySource, error) {
+	if listen == "" || target == "" {
+		return nil, errors.New("tcp-proxy session source requires --tcp-proxy-listen and --tcp-proxy-target")
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, err
+	}
+	return &TCPProxySource{
+		listen: listen,
+		target: target,
+		logger: logger,
+	}, nil
+}
+
+func (s *TCPProxySource) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	if s.logger != nil {
+		s.logger.InfoContext(ctx, "starting tcp proxy", "listen", s.listen, "target", s.target)
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections of ln until ctx is done.
+func (s *TCPProxySource) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *TCPProxySource) handle(ctx context.Context, client net.Conn) {
+	defer client.Close()
+	dialer := net.Dialer{Timeout: tcpProxyDialTimeout}
+	upstream, err := dialer.DialContext(ctx, "tcp", s.target)
+	if err != nil {
+		if s.logger != nil {
+			s.logger.WarnContext(ctx, "failed to connect to tcp proxy target", "remote_addr", client.RemoteAddr(), "error", err)
+		}
+		return
+	}
+	defer upstream.Close()
+	s.mu.Lock()
+	s.metrics.ActiveConnections++
+	s.metrics.TotalConnections++
+	s.metrics.LastTimestamp = flextime.Now()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.metrics.ActiveConnections--
+		s.metrics.LastTimestamp = flextime.Now()
+		s.mu.Unlock()
+	}()
+
+	// a side that finishes sending is forwarded as a half-close, and both sides are closed
+	// after both directions finish, or when ctx is done.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.copy(upstream, client)
+	}()
+	go func() {
+		defer wg.Done()
+		s.copy(client, upstream)
+	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		client.Close()
+		upstream.Close()
+		<-done
+	}
+}
+
+// copy copies src to dst, and closes the write side of dst when src is finished.
+func (s *TCPProxySource) copy(dst net.Conn, src net.Conn) {
+	io.Copy(tcpProxyWriter{w: dst, s: s}, src)
+	if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+		conn.CloseWrite()
+	}
+}
+
+// tcpProxyWriter records the time of the last byte transferred.
+type tcpProxyWriter struct {
+	w io.Writer
+	s *TCPProxySource
+}
+
+func (w tcpProxyWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.s.mu.Lock()
+		w.s.metrics.LastTimestamp = flextime.Now()
+		w.s.mu.Unlock()
+	}
+	return n, err
+}
+
+func (s *TCPProxySource) Name() string {
+	return SessionSourceTCPProxy
+}
+
+func (s *TCPProxySource) LastActivity() time.Time {
+	return lastActivityOf(s.Metrics())
+}
+
+func (s *TCPProxySource) Metrics() Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.metrics
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPProxySource(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	source, err := NewTCPProxySource("127.0.0.1:0", upstream.Addr().String(), nil)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	var serveErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serveErr = source.Serve(ctx, ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "PING\n")
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "PING\n", line)
+	metrics := source.Metrics()
+	require.Equal(t, 1, metrics.ActiveConnections)
+	require.Equal(t, 1, metrics.TotalConnections)
+	lastByte := metrics.LastTimestamp
+	require.False(t, lastByte.IsZero())
+
+	time.Sleep(10 * time.Millisecond)
+	fmt.Fprint(conn, "PING\n")
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, source.Metrics().LastTimestamp.After(lastByte))
+
+	conn.Close()
+	require.Eventually(t, func() bool {
+		return source.Metrics().ActiveConnections == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, source.Metrics().TotalConnections)
+
+	cancel()
+	wg.Wait()
+	require.NoError(t, serveErr)
+}
+
+func TestTCPProxySource__HalfClose(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// responds after the whole request is read, like a server of a request terminated by EOF.
+		request, _ := io.ReadAll(conn)
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(conn, "RECEIVED %s", request)
+	}()
+	source, err := NewTCPProxySource("127.0.0.1:0", upstream.Addr().String(), nil)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go source.Serve(ctx, ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "PING")
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	response, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "RECEIVED PING", string(response))
+	require.Eventually(t, func() bool {
+		return source.Metrics().ActiveConnections == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestTCPProxySource__UpstreamDown(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := upstream.Addr().String()
+	upstream.Close()
+	source, err := NewTCPProxySource("127.0.0.1:0", target, nil)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go source.Serve(ctx, ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, Metrics{}, source.Metrics())
+}
+
+func TestNewTCPProxySource(t *testing.T) {
+	_, err := NewTCPProxySource(":5432", "", nil)
+	require.Error(t, err)
+	_, err = NewTCPProxySource(":5432", "127.0.0.1", nil)
+	require.Error(t, err)
+}